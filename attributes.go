@@ -0,0 +1,92 @@
+package gospss
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Names of attributes, names starting with $@ are reserved for SPSS
+var attributeNameRegex = regexp.MustCompile(`^(\$@)?[A-Za-z@][A-Za-z0-9_.@#$]*$`)
+
+// Check the names and values of custom attributes
+func validateAttributes(attributes map[string][]string) error {
+	for name, values := range attributes {
+		if len(name) > 64 || !attributeNameRegex.MatchString(name) {
+			return fmt.Errorf("Invalid attribute name %q", name)
+		}
+
+		if len(values) == 0 {
+			return fmt.Errorf("Attribute %s needs at least one value", name)
+		}
+
+		for _, val := range values {
+			// Values are terminated by a quote and a line feed
+			if strings.Contains(val, "\n") {
+				return fmt.Errorf("Value %q of attribute %s cannot contain a line feed", val, name)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Format attributes the way extension records 17 and 18 hold them, like name('value'\n)
+// with one quoted line per value. Quotes in values are written as is, readers take everything
+// between the first and the last quote of the line. The names are sorted to keep the output stable.
+func formatAttributes(attributes map[string][]string) string {
+	names := make([]string, 0, len(attributes))
+	for name := range attributes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, name := range names {
+		b.WriteString(name)
+		b.WriteByte('(')
+		for _, val := range attributes[name] {
+			b.WriteString("'" + val + "'\n")
+		}
+		b.WriteByte(')')
+	}
+	return b.String()
+}
+
+// Parse attributes formatted by formatAttributes starting at pos, stops at the end
+// of the data or at a slash. Returns the position after the attributes.
+func parseAttributes(data string, pos int) (map[string][]string, int, error) {
+	attributes := make(map[string][]string)
+
+	for pos < len(data) && data[pos] != '/' {
+		open := strings.IndexByte(data[pos:], '(')
+		if open < 0 {
+			return nil, pos, fmt.Errorf("Missing ( after attribute %q", data[pos:])
+		}
+		name := data[pos : pos+open]
+		pos += open + 1
+
+		var values []string
+		for pos < len(data) && data[pos] != ')' {
+			end := strings.IndexByte(data[pos:], '\n')
+			if end < 0 {
+				return nil, pos, fmt.Errorf("Missing line feed after value of attribute %s", name)
+			}
+			val := data[pos : pos+end]
+			if len(val) >= 2 && val[0] == '\'' && val[len(val)-1] == '\'' {
+				val = val[1 : len(val)-1]
+			}
+			values = append(values, val)
+			pos += end + 1
+		}
+		if pos >= len(data) {
+			return nil, pos, fmt.Errorf("Missing ) after values of attribute %s", name)
+		}
+		pos++ // the closing parenthesis
+
+		attributes[name] = values
+	}
+
+	return attributes, pos, nil
+}
@@ -18,6 +18,7 @@ package gospss
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"io"
 	"strings"
 )
@@ -28,6 +29,7 @@ type bytecodeWriter struct {
 	command [8]byte
 	index   int
 	data    bytes.Buffer
+	err     error // First write error, returned by every later call
 }
 
 func newBytecodeWriter(w io.Writer, bias float64) *bytecodeWriter {
@@ -37,9 +39,11 @@ func newBytecodeWriter(w io.Writer, bias float64) *bytecodeWriter {
 func (w *bytecodeWriter) checkAndWrite() error {
 	if w.index >= len(w.command) {
 		if _, err := w.Write(w.command[:]); err != nil {
+			w.err = err
 			return err
 		}
 		if _, err := w.Write(w.data.Bytes()); err != nil {
+			w.err = err
 			return err
 		}
 		w.index = 0
@@ -49,12 +53,18 @@ func (w *bytecodeWriter) checkAndWrite() error {
 }
 
 func (w *bytecodeWriter) WriteMissing() error {
+	if w.err != nil {
+		return w.err
+	}
 	w.command[w.index] = 255
 	w.index++
 	return w.checkAndWrite()
 }
 
 func (w *bytecodeWriter) WriteNumber(number float64) error {
+	if w.err != nil {
+		return w.err
+	}
 	for i := 1.0; i <= 251; i++ {
 		if number == i-w.bias {
 			w.command[w.index] = byte(i)
@@ -69,6 +79,9 @@ func (w *bytecodeWriter) WriteNumber(number float64) error {
 }
 
 func (w *bytecodeWriter) WriteString(val string, elements int) error {
+	if w.err != nil {
+		return w.err
+	}
 	for i := 0; i < elements; i++ {
 		var p string
 		if len(val) > 8 {
@@ -101,9 +114,107 @@ func (w *bytecodeWriter) WriteString(val string, elements int) error {
 }
 
 func (w *bytecodeWriter) Flush() error {
+	if w.err != nil {
+		return w.err
+	}
 	for w.index < 8 {
 		w.command[w.index] = 0
 		w.index++
 	}
 	return w.checkAndWrite()
 }
+
+// bytecodeReader is the inverse of bytecodeWriter
+type bytecodeReader struct {
+	io.Reader
+	order   binary.ByteOrder
+	bias    float64
+	sysmis  float64
+	command [8]byte
+	index   int
+}
+
+func newBytecodeReader(r io.Reader, order binary.ByteOrder, bias float64) *bytecodeReader {
+	return &bytecodeReader{Reader: r, order: order, bias: bias, sysmis: sysmis, index: 8}
+}
+
+// Return the next code, skipping padding and reading a new command block when needed
+func (r *bytecodeReader) next() (byte, error) {
+	for {
+		if r.index >= len(r.command) {
+			if _, err := io.ReadFull(r.Reader, r.command[:]); err != nil {
+				return 0, err
+			}
+			r.index = 0
+		}
+
+		code := r.command[r.index]
+		r.index++
+
+		switch code {
+		case 0:
+			continue
+		case 252:
+			return 0, io.EOF
+		default:
+			return code, nil
+		}
+	}
+}
+
+func (r *bytecodeReader) ReadNumber() (float64, error) {
+	code, err := r.next()
+	if err != nil {
+		return 0, err
+	}
+
+	switch code {
+	case 253:
+		var number float64
+		if err := binary.Read(r.Reader, r.order, &number); err != nil {
+			return 0, unexpectedEOF(err)
+		}
+		return number, nil
+	case 254:
+		return 0, errors.New("String data found where a number was expected")
+	case 255:
+		return r.sysmis, nil
+	default:
+		return float64(code) - r.bias, nil
+	}
+}
+
+func (r *bytecodeReader) ReadString(elements int) (string, error) {
+	buf := make([]byte, elements*8)
+
+	for i := 0; i < elements; i++ {
+		code, err := r.next()
+		if err != nil {
+			if i > 0 {
+				return "", unexpectedEOF(err)
+			}
+			return "", err
+		}
+
+		switch code {
+		case 253:
+			if _, err := io.ReadFull(r.Reader, buf[i*8:i*8+8]); err != nil {
+				return "", unexpectedEOF(err)
+			}
+		case 254:
+			copy(buf[i*8:], "        ")
+		default:
+			return "", errors.New("Numeric data found where a string was expected")
+		}
+	}
+
+	return string(buf), nil
+}
+
+// Running out of data in the middle of a value means the file is truncated
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
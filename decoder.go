@@ -0,0 +1,175 @@
+package gospss
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// CaseDecoder reads the cases of a file one at a time, the memory used does not
+// depend on the number of cases
+type CaseDecoder struct {
+	cases  caseReader // Special reader for the cases
+	row    *Case      // Values of the current case, reused for every case
+	ncases int64      // Number of cases, -1 if unknown
+	count  int64      // Number of cases read
+	err    error      // First read error
+}
+
+// Case holds the values of a single case
+type Case struct {
+	layout  []variable     // Storage of the variables in a case
+	lookup  map[string]int // Position of each variable in layout
+	numbers []float64      // Values of numeric variables
+	strings []string       // Values of string variables
+	sysmis  float64        // System-missing value
+}
+
+// NewCaseDecoder - Returns a decoder of bytecode compressed cases given the variables of the
+// dictionary, a reader positioned at the start of the cases and the bias from the header
+func NewCaseDecoder(variables []Variable, r io.Reader, bias float64) *CaseDecoder {
+	layout := make([]variable, len(variables))
+	for i := range variables {
+		layout[i] = variables[i].layout()
+	}
+
+	return newCaseDecoder(layout, newBytecodeReader(r, endian, bias), sysmis, -1)
+}
+
+func newCaseDecoder(layout []variable, cases caseReader, sysmis float64, ncases int64) *CaseDecoder {
+	row := &Case{
+		layout:  layout,
+		lookup:  make(map[string]int),
+		numbers: make([]float64, len(layout)),
+		strings: make([]string, len(layout)),
+		sysmis:  sysmis,
+	}
+
+	for i, v := range layout {
+		row.lookup[v.name] = i
+	}
+
+	return &CaseDecoder{cases: cases, row: row, ncases: ncases}
+}
+
+// Next - Read the next case, returns false after the last case or when an error occurred
+func (d *CaseDecoder) Next() bool {
+	if d.err != nil || (d.ncases >= 0 && d.count >= d.ncases) {
+		return false
+	}
+
+	for i, v := range d.row.layout {
+		var err error
+
+		if v.spssType == SpssTypeString {
+			d.row.strings[i], err = readString(d.cases, v)
+		} else {
+			d.row.numbers[i], err = d.cases.ReadNumber()
+		}
+
+		if err == io.EOF && i == 0 {
+			// No more cases, when the number of cases is known there should have been more
+			if d.ncases >= 0 {
+				d.err = fmt.Errorf("Expected %d cases but found %d", d.ncases, d.count)
+			}
+			d.ncases = d.count
+			return false
+		}
+		if err != nil {
+			d.err = fmt.Errorf("Cannot read variable %s of case %d: %v", v.name, d.count+1, unexpectedEOF(err))
+			return false
+		}
+	}
+
+	d.count++
+	return true
+}
+
+// Row - Returns the current case, its values are overwritten by the next call of Next
+func (d *CaseDecoder) Row() *Case {
+	return d.row
+}
+
+// Err - Returns the error that stopped Next, nil when all cases were read
+func (d *CaseDecoder) Err() error {
+	return d.err
+}
+
+// Read a string variable, joining the segments of very long strings
+func readString(cases caseReader, v variable) (string, error) {
+	var buf bytes.Buffer
+
+	for se := 0; se < int(v.segments); se++ {
+		width := v.segmentWidth(se)
+		p, err := cases.ReadString(int(elementCount(width)))
+		if err != nil {
+			if se > 0 {
+				return "", unexpectedEOF(err)
+			}
+			return "", err
+		}
+
+		// Every segment but the last holds 252 bytes of the value
+		if se < int(v.segments)-1 {
+			buf.WriteString(p[:252])
+		} else {
+			buf.WriteString(p[:width])
+		}
+	}
+
+	return strings.TrimRight(buf.String(), " "), nil
+}
+
+// Float - Returns the value of a numeric variable, false when it is system-missing
+// or not a numeric variable
+func (c *Case) Float(name string) (float64, bool) {
+	i, found := c.lookup[name]
+	if !found || c.layout[i].spssType == SpssTypeString || c.numbers[i] == c.sysmis {
+		return 0, false
+	}
+	return c.numbers[i], true
+}
+
+// Text - Returns the value of a string variable without trailing spaces, false when
+// it is not a string variable
+func (c *Case) Text(name string) (string, bool) {
+	i, found := c.lookup[name]
+	if !found || c.layout[i].spssType != SpssTypeString {
+		return "", false
+	}
+	return c.strings[i], true
+}
+
+// Time - Returns the value of a date or datetime variable in UTC, false when it is
+// system-missing or not a numeric variable
+func (c *Case) Time(name string) (time.Time, bool) {
+	f, ok := c.Float(name)
+	if !ok {
+		return time.Time{}, false
+	}
+	return numberToDate(f), true
+}
+
+// IsMissing - Returns whether a numeric variable is system-missing
+func (c *Case) IsMissing(name string) bool {
+	i, found := c.lookup[name]
+	return found && c.layout[i].spssType != SpssTypeString && c.numbers[i] == c.sysmis
+}
+
+// Values - Returns the values formatted the way AddValueRow accepts them,
+// system-missing values are left out
+func (c *Case) Values() map[string]string {
+	values := make(map[string]string)
+
+	for i, v := range c.layout {
+		if v.spssType == SpssTypeString {
+			values[v.name] = c.strings[i]
+		} else if c.numbers[i] != c.sysmis {
+			values[v.name] = formatNumber(v.format, c.numbers[i])
+		}
+	}
+
+	return values
+}
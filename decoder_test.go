@@ -0,0 +1,125 @@
+package gospss
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+var decoderVariables = []Variable{
+	{Name: "AGE", Type: SpssTypeNumeric},
+	{Name: "NAME", Type: SpssTypeString, Width: 8},
+	{Name: "VISIT", Type: SpssTypeDate},
+	{Name: "SCORE", Type: SpssTypeNumeric, Decimal: 2},
+}
+
+var decoderVisit = time.Date(2021, 3, 14, 0, 0, 0, 0, time.UTC)
+
+// Returns the bytecode of two cases of the decoder variables, a command block followed by
+// the raw values of its 253 codes
+func decoderCases() []byte {
+	var buf bytes.Buffer
+
+	// Case 1 is 42, "John", the visit and system-missing. Case 2 is 1.5, spaces, system-missing and 0
+	buf.Write([]byte{142, 253, 253, 255, 253, 254, 255, 100})
+	buf.WriteString("John    ")
+	binary.Write(&buf, binary.LittleEndian, dateToNumber(decoderVisit))
+	binary.Write(&buf, binary.LittleEndian, 1.5)
+
+	// The end of the data
+	buf.Write([]byte{252, 0, 0, 0, 0, 0, 0, 0})
+
+	return buf.Bytes()
+}
+
+func TestCaseDecoder(t *testing.T) {
+	cases := NewCaseDecoder(decoderVariables, bytes.NewReader(decoderCases()), compressionBias)
+
+	if !cases.Next() {
+		t.Fatal(cases.Err())
+	}
+	c := cases.Row()
+	if age, ok := c.Float("AGE"); !ok || age != 42 {
+		t.Errorf("Float(AGE) = %v, %v, want 42", age, ok)
+	}
+	if name, ok := c.Text("NAME"); !ok || name != "John" {
+		t.Errorf("Text(NAME) = %q, %v, want John", name, ok)
+	}
+	if visit, ok := c.Time("VISIT"); !ok || !visit.Equal(decoderVisit) {
+		t.Errorf("Time(VISIT) = %v, %v, want %v", visit, ok, decoderVisit)
+	}
+	if !c.IsMissing("SCORE") {
+		t.Error("IsMissing(SCORE) = false, want true")
+	}
+	if score, ok := c.Float("SCORE"); ok {
+		t.Errorf("Float(SCORE) = %v, %v, want false for system-missing", score, ok)
+	}
+
+	// Values of another type or of unknown variables are not returned
+	if _, ok := c.Float("NAME"); ok {
+		t.Error("Float(NAME) of a string variable returned true")
+	}
+	if _, ok := c.Text("AGE"); ok {
+		t.Error("Text(AGE) of a numeric variable returned true")
+	}
+	if _, ok := c.Float("UNKNOWN"); ok || c.IsMissing("UNKNOWN") || c.IsMissing("NAME") {
+		t.Error("Unknown variables and strings must not be found or missing")
+	}
+
+	if !cases.Next() {
+		t.Fatal(cases.Err())
+	}
+	c = cases.Row()
+	if age, ok := c.Float("AGE"); !ok || age != 1.5 {
+		t.Errorf("Float(AGE) = %v, %v, want 1.5", age, ok)
+	}
+	if name, ok := c.Text("NAME"); !ok || name != "" {
+		t.Errorf("Text(NAME) = %q, %v, want an empty string", name, ok)
+	}
+	if visit, ok := c.Time("VISIT"); ok || !c.IsMissing("VISIT") {
+		t.Errorf("Time(VISIT) = %v, %v, want system-missing", visit, ok)
+	}
+	if score, ok := c.Float("SCORE"); !ok || score != 0 {
+		t.Errorf("Float(SCORE) = %v, %v, want 0", score, ok)
+	}
+	if want := map[string]string{"AGE": "1.5", "NAME": "", "SCORE": "0"}; !reflect.DeepEqual(c.Values(), want) {
+		t.Errorf("Values() = %q, want %q", c.Values(), want)
+	}
+
+	if cases.Next() {
+		t.Error("Next() returned true after the end of the data")
+	}
+	if err := cases.Err(); err != nil {
+		t.Errorf("Err() = %v, want nil", err)
+	}
+}
+
+func TestCaseDecoderTruncated(t *testing.T) {
+	data := decoderCases()
+
+	tests := []struct {
+		name string
+		data []byte
+		err  string
+	}{
+		{"raw string", data[:12], "Cannot read variable NAME of case 1: unexpected EOF"},
+		{"raw number", data[:20], "Cannot read variable VISIT of case 1: unexpected EOF"},
+		{"second case", data[:28], "Cannot read variable AGE of case 2: unexpected EOF"},
+		{"string for number", append([]byte{254}, data[1:]...), "Cannot read variable AGE of case 1: String data found"},
+		{"number for string", append([]byte{142, 142}, data[2:]...), "Cannot read variable NAME of case 1: Numeric data found"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cases := NewCaseDecoder(decoderVariables, bytes.NewReader(tt.data), compressionBias)
+			for cases.Next() {
+			}
+			if err := cases.Err(); err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("Err() = %v, want it to contain %q", err, tt.err)
+			}
+		})
+	}
+}
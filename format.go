@@ -0,0 +1,544 @@
+package gospss
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SpssFormat declares the print and write formats of variables, refer to
+// https://www.gnu.org/software/pspp/pspp-dev/html_node/Variable-Record.html
+type SpssFormat int
+
+const (
+	// SpssFormatA is the string format
+	SpssFormatA SpssFormat = 1
+	// SpssFormatAHex is the hexadecimal string format
+	SpssFormatAHex SpssFormat = 2
+	// SpssFormatComma is the number format with commas as grouping separator
+	SpssFormatComma SpssFormat = 3
+	// SpssFormatDollar is the number format with a dollar sign and commas
+	SpssFormatDollar SpssFormat = 4
+	// SpssFormatF is the default number format
+	SpssFormatF SpssFormat = 5
+	// SpssFormatIB is the integer binary format
+	SpssFormatIB SpssFormat = 6
+	// SpssFormatPIBHex is the hexadecimal positive integer binary format
+	SpssFormatPIBHex SpssFormat = 7
+	// SpssFormatP is the packed decimal format
+	SpssFormatP SpssFormat = 8
+	// SpssFormatPIB is the positive integer binary format
+	SpssFormatPIB SpssFormat = 9
+	// SpssFormatPK is the unsigned packed decimal format
+	SpssFormatPK SpssFormat = 10
+	// SpssFormatRB is the real binary format
+	SpssFormatRB SpssFormat = 11
+	// SpssFormatRBHex is the hexadecimal real binary format
+	SpssFormatRBHex SpssFormat = 12
+	// SpssFormatZ is the zoned decimal format
+	SpssFormatZ SpssFormat = 15
+	// SpssFormatN is the number format with leading zeros
+	SpssFormatN SpssFormat = 16
+	// SpssFormatE is the scientific notation format
+	SpssFormatE SpssFormat = 17
+	// SpssFormatDate is the dd-mmm-yyyy date format
+	SpssFormatDate SpssFormat = 20
+	// SpssFormatTime is the hh:mm:ss time format
+	SpssFormatTime SpssFormat = 21
+	// SpssFormatDatetime is the dd-mmm-yyyy hh:mm:ss datetime format
+	SpssFormatDatetime SpssFormat = 22
+	// SpssFormatADate is the mm/dd/yyyy date format
+	SpssFormatADate SpssFormat = 23
+	// SpssFormatJDate is the yyyyddd date format
+	SpssFormatJDate SpssFormat = 24
+	// SpssFormatDTime is the dd hh:mm:ss time format
+	SpssFormatDTime SpssFormat = 25
+	// SpssFormatWkday is the day of the week format
+	SpssFormatWkday SpssFormat = 26
+	// SpssFormatMonth is the month format
+	SpssFormatMonth SpssFormat = 27
+	// SpssFormatMoyr is the mmm yyyy date format
+	SpssFormatMoyr SpssFormat = 28
+	// SpssFormatQyr is the q Q yyyy date format
+	SpssFormatQyr SpssFormat = 29
+	// SpssFormatWkyr is the ww WK yyyy date format
+	SpssFormatWkyr SpssFormat = 30
+	// SpssFormatPct is the number format with a percent sign
+	SpssFormatPct SpssFormat = 31
+	// SpssFormatDot is the number format with dots as grouping separator
+	SpssFormatDot SpssFormat = 32
+	// SpssFormatCCA is the first custom currency format
+	SpssFormatCCA SpssFormat = 33
+	// SpssFormatCCB is the second custom currency format
+	SpssFormatCCB SpssFormat = 34
+	// SpssFormatCCC is the third custom currency format
+	SpssFormatCCC SpssFormat = 35
+	// SpssFormatCCD is the fourth custom currency format
+	SpssFormatCCD SpssFormat = 36
+	// SpssFormatCCE is the fifth custom currency format
+	SpssFormatCCE SpssFormat = 37
+	// SpssFormatEDate is the dd.mm.yyyy date format
+	SpssFormatEDate SpssFormat = 38
+	// SpssFormatSDate is the yyyy/mm/dd date format
+	SpssFormatSDate SpssFormat = 39
+	// SpssFormatMTime is the mm:ss time format
+	SpssFormatMTime SpssFormat = 40
+	// SpssFormatYMDHMS is the yyyy-mm-dd hh:mm:ss datetime format
+	SpssFormatYMDHMS SpssFormat = 41
+)
+
+// Kind of values a format is used for
+type formatFamily int
+
+const (
+	familyString   formatFamily = iota // Text
+	familyNumber                       // Plain numbers
+	familyDate                         // Dates without time of day
+	familyDatetime                     // Dates with time of day
+	familyTime                         // Durations in seconds
+	familyWkday                        // Day of the week, 1 is Sunday
+	familyMonth                        // Month of the year
+)
+
+// Limits of a format
+type formatInfo struct {
+	name         string
+	family       formatFamily
+	minWidth     int16
+	maxWidth     int16
+	defaultWidth int16
+	decimalWidth int16 // Width taken besides the decimals, 0 when decimals are not allowed
+}
+
+var formats = map[SpssFormat]formatInfo{
+	SpssFormatA:        {"A", familyString, 1, 32767, 40, 0},
+	SpssFormatAHex:     {"AHEX", familyString, 2, 32767, 80, 0},
+	SpssFormatComma:    {"COMMA", familyNumber, 1, 40, 8, 1},
+	SpssFormatDollar:   {"DOLLAR", familyNumber, 2, 40, 8, 2},
+	SpssFormatF:        {"F", familyNumber, 1, 40, 8, 1},
+	SpssFormatIB:       {"IB", familyNumber, 1, 8, 4, 1},
+	SpssFormatPIBHex:   {"PIBHEX", familyNumber, 2, 16, 8, 0},
+	SpssFormatP:        {"P", familyNumber, 1, 16, 8, 1},
+	SpssFormatPIB:      {"PIB", familyNumber, 1, 8, 4, 1},
+	SpssFormatPK:       {"PK", familyNumber, 1, 16, 8, 1},
+	SpssFormatRB:       {"RB", familyNumber, 2, 8, 8, 0},
+	SpssFormatRBHex:    {"RBHEX", familyNumber, 4, 16, 16, 0},
+	SpssFormatZ:        {"Z", familyNumber, 1, 40, 8, 1},
+	SpssFormatN:        {"N", familyNumber, 1, 40, 8, 1},
+	SpssFormatE:        {"E", familyNumber, 6, 40, 10, 7},
+	SpssFormatDate:     {"DATE", familyDate, 9, 40, 11, 0},
+	SpssFormatTime:     {"TIME", familyTime, 5, 40, 8, 9},
+	SpssFormatDatetime: {"DATETIME", familyDatetime, 17, 40, 20, 20},
+	SpssFormatADate:    {"ADATE", familyDate, 8, 40, 10, 0},
+	SpssFormatJDate:    {"JDATE", familyDate, 5, 40, 7, 0},
+	SpssFormatDTime:    {"DTIME", familyTime, 8, 40, 11, 12},
+	SpssFormatWkday:    {"WKDAY", familyWkday, 2, 40, 9, 0},
+	SpssFormatMonth:    {"MONTH", familyMonth, 3, 40, 9, 0},
+	SpssFormatMoyr:     {"MOYR", familyDate, 6, 40, 8, 0},
+	SpssFormatQyr:      {"QYR", familyDate, 6, 40, 8, 0},
+	SpssFormatWkyr:     {"WKYR", familyDate, 8, 40, 10, 0},
+	SpssFormatPct:      {"PCT", familyNumber, 2, 40, 8, 2},
+	SpssFormatDot:      {"DOT", familyNumber, 1, 40, 8, 1},
+	SpssFormatCCA:      {"CCA", familyNumber, 2, 40, 8, 1},
+	SpssFormatCCB:      {"CCB", familyNumber, 2, 40, 8, 1},
+	SpssFormatCCC:      {"CCC", familyNumber, 2, 40, 8, 1},
+	SpssFormatCCD:      {"CCD", familyNumber, 2, 40, 8, 1},
+	SpssFormatCCE:      {"CCE", familyNumber, 2, 40, 8, 1},
+	SpssFormatEDate:    {"EDATE", familyDate, 8, 40, 10, 0},
+	SpssFormatSDate:    {"SDATE", familyDate, 8, 40, 10, 0},
+	SpssFormatMTime:    {"MTIME", familyTime, 5, 40, 8, 6},
+	SpssFormatYMDHMS:   {"YMDHMS", familyDatetime, 16, 40, 19, 19},
+}
+
+// Layouts of the date formats, the first is used for writing and all are accepted when reading
+var dateLayouts = map[SpssFormat][]string{
+	SpssFormatDate:     {"02-Jan-2006", "02-Jan-06"},
+	SpssFormatADate:    {"01/02/2006", "01/02/06"},
+	SpssFormatEDate:    {"02.01.2006", "02.01.06"},
+	SpssFormatSDate:    {"2006/01/02", "06/01/02"},
+	SpssFormatJDate:    {"2006002", "06002"},
+	SpssFormatMoyr:     {"Jan 2006", "Jan 06"},
+	SpssFormatDatetime: {"02-Jan-2006 15:04:05", "02-Jan-2006 15:04"},
+	SpssFormatYMDHMS:   {"2006-01-02 15:04:05", "2006-01-02 15:04"},
+}
+
+// String - Returns the name of the format as used in SPSS syntax
+func (f SpssFormat) String() string {
+	if info, found := formats[f]; found {
+		return info.name
+	}
+	return "FORMAT" + strconv.Itoa(int(f))
+}
+
+// Returns the family of the format, unknown formats are numbers
+func (f SpssFormat) family() formatFamily {
+	if info, found := formats[f]; found {
+		return info.family
+	}
+	return familyNumber
+}
+
+// Returns whether values of the format are dates, with or without time of day
+func (f SpssFormat) isDate() bool {
+	return f.family() == familyDate || f.family() == familyDatetime
+}
+
+// SpssFormatSpec defines a format with its width and decimal, written as F8.2, A20 or DATE11
+type SpssFormatSpec struct {
+	Format  SpssFormat
+	Width   int16
+	Decimal int8
+}
+
+// ParseFormatSpec - Parse a format specifier like F8.2, COMMA10.1, A255 or ADATE10 and check
+// the width and decimal against the limits of the format
+func ParseFormatSpec(spec string) (SpssFormatSpec, error) {
+	s := strings.ToUpper(strings.TrimSpace(spec))
+
+	i := strings.IndexFunc(s, func(r rune) bool { return r < 'A' || r > 'Z' })
+	if i <= 0 {
+		return SpssFormatSpec{}, fmt.Errorf("Invalid format specifier %q, expected a name and width like F8.2", spec)
+	}
+
+	var format SpssFormat
+	for f, info := range formats {
+		if info.name == s[:i] {
+			format = f
+			break
+		}
+	}
+	if format == 0 {
+		return SpssFormatSpec{}, fmt.Errorf("Unknown format %s in specifier %q", s[:i], spec)
+	}
+
+	width, decimal := s[i:], "0"
+	if dot := strings.Index(width, "."); dot >= 0 {
+		width, decimal = width[:dot], width[dot+1:]
+	}
+
+	w, err := strconv.ParseInt(width, 10, 16)
+	if err != nil {
+		return SpssFormatSpec{}, fmt.Errorf("Invalid width in format specifier %q", spec)
+	}
+	d, err := strconv.ParseInt(decimal, 10, 8)
+	if err != nil || d < 0 {
+		return SpssFormatSpec{}, fmt.Errorf("Invalid decimal in format specifier %q", spec)
+	}
+
+	fs := SpssFormatSpec{Format: format, Width: int16(w), Decimal: int8(d)}
+	if err := validateFormat(fs.Format, fs.Width, fs.Decimal); err != nil {
+		return SpssFormatSpec{}, fmt.Errorf("Invalid format specifier %q: %v", spec, err)
+	}
+
+	return fs, nil
+}
+
+// String - Returns the format specifier, the decimal is left out when it is 0
+func (fs SpssFormatSpec) String() string {
+	if fs.Decimal > 0 {
+		return fmt.Sprintf("%s%d.%d", fs.Format, fs.Width, fs.Decimal)
+	}
+	return fmt.Sprintf("%s%d", fs.Format, fs.Width)
+}
+
+// SetFormat - Set the type, format, width and decimal of the variable from a format specifier
+// like F8.2 or A20, the type follows from the format
+func (v *Variable) SetFormat(spec string) error {
+	fs, err := ParseFormatSpec(spec)
+	if err != nil {
+		return err
+	}
+
+	switch fs.Format {
+	case SpssFormatA:
+		v.Type, v.Format, v.Width, v.Decimal = SpssTypeString, fs.Format, fs.Width, 0
+	case SpssFormatAHex:
+		// Every byte of the string takes two hexadecimal digits
+		v.Type, v.Format, v.Width, v.Decimal = SpssTypeString, fs.Format, fs.Width/2, 0
+	default:
+		v.setPrint(fs.Format, fs.Width, fs.Decimal)
+	}
+
+	return nil
+}
+
+// Set the type and format of a variable read from a file, the type follows from the family
+// of the print format
+func (v *Variable) setPrint(format SpssFormat, width int16, decimal int8) {
+	v.Format = format
+	v.Decimal = decimal
+
+	switch format.family() {
+	case familyString:
+		v.Type = SpssTypeString
+		return // the width of a string is its storage width
+	case familyDate:
+		v.Type = SpssTypeDate
+	case familyDatetime:
+		v.Type = SpssTypeDatetime
+	default:
+		v.Type = SpssTypeNumeric
+	}
+	v.Width = width
+}
+
+// Validate the width and decimal of a format
+func validateFormat(f SpssFormat, width int16, decimal int8) error {
+	info, found := formats[f]
+	if !found {
+		return fmt.Errorf("Unknown format %d", f)
+	}
+
+	if width < info.minWidth || width > info.maxWidth {
+		return fmt.Errorf("Cannot set width of %d on format %s, value must be between %d and %d", width, info.name, info.minWidth, info.maxWidth)
+	}
+
+	if f == SpssFormatAHex || f == SpssFormatPIBHex || f == SpssFormatRBHex {
+		if width%2 != 0 {
+			return fmt.Errorf("Cannot set width of %d on format %s, value must be even", width, info.name)
+		}
+	}
+
+	if decimal == 0 {
+		return nil
+	}
+
+	if info.decimalWidth == 0 {
+		return fmt.Errorf("Cannot set decimal of %d on format %s, it has no decimals", decimal, info.name)
+	}
+
+	if decimal > 16 {
+		return fmt.Errorf("Cannot set decimal of %d on format %s, value must be between 0 and 16", decimal, info.name)
+	}
+
+	// Time formats need room for the seconds and the dot, numbers for the signs and the exponent
+	if width < info.decimalWidth+int16(decimal) {
+		return fmt.Errorf("Cannot set decimal of %d on format %s with width %d, the width must be at least %d",
+			decimal, info.name, width, info.decimalWidth+int16(decimal))
+	}
+
+	return nil
+}
+
+// Parse a value into the float stored in the file, the format decides how the value is read
+func parseNumber(f SpssFormat, val string) (float64, error) {
+	switch f.family() {
+	case familyDate, familyDatetime:
+		return parseDate(f, val)
+	case familyTime:
+		return parseDuration(f, val)
+	case familyWkday:
+		return parseName(val, 7, func(i int) string { return time.Weekday(i - 1).String() })
+	case familyMonth:
+		return parseName(val, 12, func(i int) string { return time.Month(i).String() })
+	}
+
+	switch f {
+	case SpssFormatComma, SpssFormatCCA, SpssFormatCCB, SpssFormatCCC, SpssFormatCCD, SpssFormatCCE:
+		val = strings.Replace(val, ",", "", -1)
+	case SpssFormatDollar:
+		val = strings.Replace(strings.Replace(val, ",", "", -1), "$", "", 1)
+	case SpssFormatDot:
+		val = strings.Replace(strings.Replace(val, ".", "", -1), ",", ".", 1)
+	case SpssFormatPct:
+		val = strings.TrimSuffix(val, "%")
+	}
+
+	return strconv.ParseFloat(strings.TrimSpace(val), 64)
+}
+
+// Format a number stored in the file the way parseNumber accepts it
+func formatNumber(f SpssFormat, number float64) string {
+	switch f.family() {
+	case familyDate, familyDatetime:
+		return formatDate(f, number)
+	case familyTime:
+		return formatDuration(f, number)
+	case familyWkday:
+		if number >= 1 && number <= 7 && number == math.Trunc(number) {
+			return time.Weekday(int(number) - 1).String()
+		}
+	case familyMonth:
+		if number >= 1 && number <= 12 && number == math.Trunc(number) {
+			return time.Month(int(number)).String()
+		}
+	}
+
+	s := strconv.FormatFloat(number, 'f', -1, 64)
+	if f == SpssFormatDot {
+		s = strings.Replace(s, ".", ",", 1)
+	}
+	return s
+}
+
+// Seconds since the start of the Gregorian calendar of a time
+func dateToNumber(d time.Time) float64 {
+	return float64(d.Unix()+TimeOffset) + float64(d.Nanosecond())/1e9
+}
+
+// Time of the seconds since the start of the Gregorian calendar in UTC
+func numberToDate(f float64) time.Time {
+	sec := math.Floor(f)
+	return time.Unix(int64(sec)-TimeOffset, int64((f-sec)*1e9)).UTC()
+}
+
+func parseDate(f SpssFormat, val string) (float64, error) {
+	val = strings.TrimSpace(val)
+
+	switch f {
+	case SpssFormatQyr, SpssFormatWkyr:
+		sep := " Q "
+		if f == SpssFormatWkyr {
+			sep = " WK "
+		}
+		parts := strings.Split(strings.ToUpper(val), sep)
+		if len(parts) != 2 {
+			return 0, fmt.Errorf("Cannot parse %q as %s", val, f)
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+		if err != nil {
+			return 0, fmt.Errorf("Cannot parse %q as %s", val, f)
+		}
+		year, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+		if err != nil {
+			return 0, fmt.Errorf("Cannot parse %q as %s", val, f)
+		}
+		if f == SpssFormatQyr {
+			if n < 1 || n > 4 {
+				return 0, fmt.Errorf("Invalid quarter %d in %q", n, val)
+			}
+			return dateToNumber(time.Date(year, time.Month(n*3-2), 1, 0, 0, 0, 0, time.UTC)), nil
+		}
+		if n < 1 || n > 53 {
+			return 0, fmt.Errorf("Invalid week %d in %q", n, val)
+		}
+		return dateToNumber(time.Date(year, 1, 1+(n-1)*7, 0, 0, 0, 0, time.UTC)), nil
+	}
+
+	var err error
+	for _, layout := range dateLayouts[f] {
+		var d time.Time
+		if d, err = time.Parse(layout, val); err == nil {
+			return dateToNumber(d), nil
+		}
+	}
+	return 0, err
+}
+
+func formatDate(f SpssFormat, number float64) string {
+	d := numberToDate(number)
+
+	switch f {
+	case SpssFormatQyr:
+		return fmt.Sprintf("%d Q %d", (int(d.Month())+2)/3, d.Year())
+	case SpssFormatWkyr:
+		return fmt.Sprintf("%d WK %d", (d.YearDay()-1)/7+1, d.Year())
+	case SpssFormatDatetime, SpssFormatYMDHMS:
+		s := d.Format(dateLayouts[f][0])
+		if fraction := d.Nanosecond(); fraction > 0 {
+			s += strings.TrimRight(fmt.Sprintf(".%09d", fraction), "0")
+		}
+		return s
+	default:
+		return d.Format(dateLayouts[f][0])
+	}
+}
+
+// Parse a duration like 1:30:00 for TIME, 2 01:30:00 for DTIME or 90:00 for MTIME into seconds
+func parseDuration(f SpssFormat, val string) (float64, error) {
+	val = strings.TrimSpace(val)
+
+	negative := strings.HasPrefix(val, "-")
+	if negative {
+		val = val[1:]
+	}
+
+	days := 0.0
+	if f == SpssFormatDTime {
+		parts := strings.SplitN(val, " ", 2)
+		if len(parts) != 2 {
+			return 0, fmt.Errorf("Cannot parse %q as %s, expected days and time", val, f)
+		}
+		d, err := strconv.ParseUint(parts[0], 10, 32)
+		if err != nil {
+			return 0, fmt.Errorf("Cannot parse %q as %s: %v", val, f, err)
+		}
+		days = float64(d)
+		val = strings.TrimSpace(parts[1])
+	}
+
+	parts := strings.Split(val, ":")
+	if len(parts) < 2 || len(parts) > 3 || (f == SpssFormatMTime && len(parts) != 2) {
+		return 0, fmt.Errorf("Cannot parse %q as %s", val, f)
+	}
+
+	var n []float64
+	for i, part := range parts {
+		p, err := strconv.ParseFloat(part, 64)
+		// Only the last part can have a fraction
+		if err != nil || p < 0 || math.IsInf(p, 0) || (i < len(parts)-1 && p != math.Trunc(p)) {
+			return 0, fmt.Errorf("Cannot parse %q as %s", val, f)
+		}
+		n = append(n, p)
+	}
+
+	var seconds float64
+	switch {
+	case f == SpssFormatMTime:
+		seconds = n[0]*60 + n[1]
+	case len(n) == 2:
+		seconds = n[0]*3600 + n[1]*60
+	default:
+		seconds = n[0]*3600 + n[1]*60 + n[2]
+	}
+	seconds += days * 86400
+
+	if negative {
+		seconds = -seconds
+	}
+	return seconds, nil
+}
+
+func formatDuration(f SpssFormat, seconds float64) string {
+	sign := ""
+	if seconds < 0 {
+		sign = "-"
+		seconds = -seconds
+	}
+
+	whole := math.Floor(seconds)
+	fraction := strconv.FormatFloat(seconds-whole, 'f', -1, 64)[1:] // .5 or empty
+	s := int64(whole)
+
+	switch f {
+	case SpssFormatMTime:
+		return fmt.Sprintf("%s%d:%02d%s", sign, s/60, s%60, fraction)
+	case SpssFormatDTime:
+		return fmt.Sprintf("%s%d %02d:%02d:%02d%s", sign, s/86400, s/3600%24, s/60%60, s%60, fraction)
+	default:
+		return fmt.Sprintf("%s%d:%02d:%02d%s", sign, s/3600, s/60%60, s%60, fraction)
+	}
+}
+
+// Parse a weekday or month by number, name or the first three letters of the name
+func parseName(val string, count int, name func(int) string) (float64, error) {
+	val = strings.TrimSpace(val)
+
+	if n, err := strconv.Atoi(val); err == nil {
+		if n < 1 || n > count {
+			return 0, fmt.Errorf("Value %d must be between 1 and %d", n, count)
+		}
+		return float64(n), nil
+	}
+
+	for i := 1; i <= count; i++ {
+		full := name(i)
+		if strings.EqualFold(val, full) || (len(val) == 3 && strings.EqualFold(val, full[:3])) {
+			return float64(i), nil
+		}
+	}
+
+	return 0, fmt.Errorf("Unknown name %q", val)
+}
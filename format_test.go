@@ -0,0 +1,113 @@
+package gospss
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseFormatSpec(t *testing.T) {
+	tests := []struct {
+		spec string
+		want SpssFormatSpec
+	}{
+		{"F8.2", SpssFormatSpec{SpssFormatF, 8, 2}},
+		{"F8", SpssFormatSpec{SpssFormatF, 8, 0}},
+		{"f8.2", SpssFormatSpec{SpssFormatF, 8, 2}},
+		{" COMMA10.1 ", SpssFormatSpec{SpssFormatComma, 10, 1}},
+		{"DOLLAR3.1", SpssFormatSpec{SpssFormatDollar, 3, 1}},
+		{"PCT6.2", SpssFormatSpec{SpssFormatPct, 6, 2}},
+		{"E12.5", SpssFormatSpec{SpssFormatE, 12, 5}},
+		{"A1", SpssFormatSpec{SpssFormatA, 1, 0}},
+		{"A255", SpssFormatSpec{SpssFormatA, 255, 0}},
+		{"A32767", SpssFormatSpec{SpssFormatA, 32767, 0}},
+		{"AHEX20", SpssFormatSpec{SpssFormatAHex, 20, 0}},
+		{"ADATE10", SpssFormatSpec{SpssFormatADate, 10, 0}},
+		{"DATE11", SpssFormatSpec{SpssFormatDate, 11, 0}},
+		{"TIME8", SpssFormatSpec{SpssFormatTime, 8, 0}},
+		{"TIME10.1", SpssFormatSpec{SpssFormatTime, 10, 1}},
+		{"DTIME13.1", SpssFormatSpec{SpssFormatDTime, 13, 1}},
+		{"DATETIME20", SpssFormatSpec{SpssFormatDatetime, 20, 0}},
+		{"DATETIME23.3", SpssFormatSpec{SpssFormatDatetime, 23, 3}},
+		{"YMDHMS20.1", SpssFormatSpec{SpssFormatYMDHMS, 20, 1}},
+		{"MTIME7.1", SpssFormatSpec{SpssFormatMTime, 7, 1}},
+		{"F40.16", SpssFormatSpec{SpssFormatF, 40, 16}},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseFormatSpec(tt.spec)
+		if err != nil {
+			t.Errorf("ParseFormatSpec(%q) failed: %v", tt.spec, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseFormatSpec(%q) = %+v, want %+v", tt.spec, got, tt.want)
+		}
+	}
+}
+
+func TestParseFormatSpecInvalid(t *testing.T) {
+	tests := []struct {
+		spec string
+		err  string
+	}{
+		// Names
+		{"", "expected a name and width"},
+		{"8.2", "expected a name and width"},
+		{"XYZ8", "Unknown format XYZ"},
+		{"FF8", "Unknown format FF"},
+
+		// Widths
+		{"F", "expected a name and width"},
+		{"F.2", "Invalid width"},
+		{"F0", "value must be between 1 and 40"},
+		{"F-8", "value must be between 1 and 40"},
+		{"F41", "value must be between 1 and 40"},
+		{"A32768", "Invalid width"},
+		{"DATE8", "value must be between 9 and 40"},
+		{"DATETIME16", "value must be between 17 and 40"},
+		{"AHEX3", "value must be even"},
+		{"PIBHEX5", "value must be even"},
+
+		// Decimals
+		{"F8.", "Invalid decimal"},
+		{"F8.x", "Invalid decimal"},
+		{"F8.-1", "Invalid decimal"},
+		{"F8.2.1", "Invalid decimal"},
+		{"F40.17", "value must be between 0 and 16"},
+		{"A8.1", "it has no decimals"},
+		{"DATE11.1", "it has no decimals"},
+		{"F2.2", "the width must be at least 3"},
+		{"DOLLAR2.1", "the width must be at least 3"},
+		{"PCT2.1", "the width must be at least 3"},
+		{"E7.5", "the width must be at least 12"},
+		{"TIME9.1", "the width must be at least 10"},
+		{"DTIME12.1", "the width must be at least 13"},
+		{"DATETIME19.1", "the width must be at least 21"},
+		{"DATETIME20.1", "the width must be at least 21"},
+		{"YMDHMS19.1", "the width must be at least 20"},
+		{"MTIME6.1", "the width must be at least 7"},
+	}
+
+	for _, tt := range tests {
+		_, err := ParseFormatSpec(tt.spec)
+		if err == nil {
+			t.Errorf("ParseFormatSpec(%q) succeeded, want error containing %q", tt.spec, tt.err)
+			continue
+		}
+		if !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("ParseFormatSpec(%q) error = %q, want it to contain %q", tt.spec, err, tt.err)
+		}
+	}
+}
+
+func TestFormatSpecString(t *testing.T) {
+	for _, spec := range []string{"F8.2", "F8", "COMMA10.1", "A255", "AHEX20", "ADATE10", "DATETIME23.3"} {
+		fs, err := ParseFormatSpec(spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := fs.String(); got != spec {
+			t.Errorf("ParseFormatSpec(%q).String() = %q", spec, got)
+		}
+	}
+}
@@ -1,6 +1,8 @@
 package gospss
 
 import (
+	"fmt"
+	"math"
 	"regexp"
 	"strconv"
 	"strings"
@@ -32,12 +34,60 @@ const (
 	SpssMeasureScale SpssMeasure = "SCALE"
 )
 
+// SpssRole declares the role of a variable in dialogs that preselect variables
+type SpssRole string
+
+const (
+	// SpssRoleInput is the role of predictors, this is the default in SPSS
+	SpssRoleInput SpssRole = "INPUT"
+	// SpssRoleTarget is the role of outputs
+	SpssRoleTarget SpssRole = "TARGET"
+	// SpssRoleBoth is the role of variables used as input and target
+	SpssRoleBoth SpssRole = "BOTH"
+	// SpssRoleNone is the role of variables without a role assignment
+	SpssRoleNone SpssRole = "NONE"
+	// SpssRolePartition is the role of variables that partition the data into samples
+	SpssRolePartition SpssRole = "PARTITION"
+	// SpssRoleSplit is the role of variables that split the data
+	SpssRoleSplit SpssRole = "SPLIT"
+)
+
+// Codes of the roles in the $@Role attribute
+var roleCodes = []SpssRole{SpssRoleInput, SpssRoleTarget, SpssRoleBoth, SpssRoleNone, SpssRolePartition, SpssRoleSplit}
+
+// SpssCompression declares the different ways to store the cases
+type SpssCompression int32
+
+const (
+	// SpssCompressionNone stores every case element uncompressed
+	SpssCompressionNone SpssCompression = 0
+	// SpssCompressionBytecode stores the cases bytecode compressed, this is the default
+	SpssCompressionBytecode SpssCompression = 1
+	// SpssCompressionZlib stores the bytecode deflated in zlib blocks (ZSAV)
+	SpssCompressionZlib SpssCompression = 2
+)
+
 // Label defines the structure for value labels on variables
 type Label struct {
 	Value string
 	Desc  string
 }
 
+// MissingValues defines the user-missing values of a variable. Numeric variables
+// accept up to three discrete values, a range, or a range and one discrete value.
+// String variables accept up to three discrete values of at most 8 bytes.
+type MissingValues struct {
+	Values []string
+	Range  *MissingRange
+}
+
+// MissingRange defines an inclusive range of user-missing values, use LO or HI
+// as bound for an open ended range
+type MissingRange struct {
+	Low  string
+	High string
+}
+
 // // SpssConfig defines the structure for generating your SPSS file
 // type SpssConfig struct {
 // 	Variables []Variable
@@ -53,21 +103,32 @@ type Variable struct {
 	Width   int16
 	Label   string
 	Labels  []Label
+	Format  SpssFormat // Print and write format, derived from Type when not set
+	Role    SpssRole   // Role in dialogs, not written when empty
+
+	MissingValues MissingValues
+	Attributes    map[string][]string // Custom attributes, every attribute has one or more values
 }
 
 type variable struct {
-	index     int32
-	name      string
-	shortName string
-	spssType  SpssType
-	calcType  int32
-	measure   int8
-	decimal   int8
-	width     int16
-	format    int8
-	segments  int16
-	label     string
-	labels    []Label
+	index          int32
+	name           string
+	shortName      string
+	segmentNames   []string
+	spssType       SpssType
+	calcType       int32
+	measure        int8
+	decimal        int8
+	width          int16
+	format         SpssFormat
+	segments       int16
+	label          string
+	labels         []Label
+	labelValues    []float64 // Parsed values of numeric labels
+	missingCode    int32     // n_missing_values, negative when a range is present
+	missing        []float64 // Numeric missing values, range bounds first
+	missingStrings []string  // String missing values
+	attributes     string    // Custom attributes and role as written in the file
 }
 
 // Value defines the values for each field
@@ -78,6 +139,36 @@ type Value struct {
 
 var nameValidatorRegex = regexp.MustCompile(`(?si)^[a-z@][a-z0-9!._#@$]*[^\.]$`)
 
+var (
+	sysmis  = -math.MaxFloat64
+	highest = math.MaxFloat64
+	lowest  = math.Nextafter(-math.MaxFloat64, 0)
+)
+
+// Format a bound of a missing range, the extremes are returned as LO and HI
+func formatMissingBound(format SpssFormat, f float64) string {
+	switch {
+	case f <= lowest:
+		return "LO"
+	case f >= highest:
+		return "HI"
+	default:
+		return formatNumber(format, f)
+	}
+}
+
+// Parse a bound of a missing range, LO and HI are accepted as keyword
+func parseMissingBound(format SpssFormat, val string) (float64, error) {
+	switch strings.ToUpper(val) {
+	case "LO", "LOWEST":
+		return lowest, nil
+	case "HI", "HIGHEST":
+		return highest, nil
+	default:
+		return parseNumber(format, val)
+	}
+}
+
 func (v *Variable) getMeasure() int8 {
 	switch v.Measure {
 	case SpssMeasureScale:
@@ -89,18 +180,151 @@ func (v *Variable) getMeasure() int8 {
 	}
 }
 
+// Validate the name, decimal and width of the variable, the default width is
+// set when no width is given
+func (v *Variable) validate() error {
+	// Check if name is empty
+	if v.Name == "" {
+		return fmt.Errorf("Name cannot be empty")
+	}
+
+	if len(v.Name) > 64 {
+		return fmt.Errorf("Name cannot exceed 64 characters: %s", v.Name)
+	}
+
+	matched := nameValidatorRegex.MatchString(v.Name)
+
+	if !matched {
+		return fmt.Errorf("Name %s does not meet the requirements for SPSS, please refer to https://www.ibm.com/support/knowledgecenter/en/SSLVMB_24.0.0/spss/base/syn_variables_variable_names.html", v.Name)
+	}
+
+	// Check decimal range
+	if v.Decimal < 0 || v.Decimal > 16 {
+		return fmt.Errorf("Cannot set decimal of %d, value must be between 0 and 16", v.Decimal)
+	}
+
+	if v.Width < 0 || v.Width > 32767 {
+		return fmt.Errorf("Cannot set width of %d, value must be between 0 and 32767", v.Width)
+	}
+
+	if v.Type != SpssTypeString && v.Width > 40 {
+		return fmt.Errorf("Cannot set width of %d on type %s, value must be between 1 and 40", v.Width, v.Type)
+	}
+
+	// Check if width is set, get the default otherwise
+	if v.Width == 0 {
+		if err := v.setDefaultWidth(); err != nil {
+			return err
+		}
+	} else {
+		if v.Width <= int16(v.Decimal) {
+			return fmt.Errorf("Width cannot be less or equal to decimal")
+		}
+	}
+
+	return v.validateFormat()
+}
+
+// Check that the format fits the type, width and decimal of the variable
+func (v *Variable) validateFormat() error {
+	format := v.getPrint()
+	if _, found := formats[format]; !found {
+		return fmt.Errorf("Unknown format %d on variable %s", format, v.Name)
+	}
+
+	family := format.family()
+	if (v.Type == SpssTypeString) != (family == familyString) ||
+		((v.Type == SpssTypeDate || v.Type == SpssTypeDatetime) && !format.isDate()) {
+		return fmt.Errorf("Cannot use format %s on variable %s of type %s", format, v.Name, v.Type)
+	}
+
+	if v.Type == SpssTypeString {
+		// Every byte takes two hexadecimal digits and the width of a format is at most 255
+		if format == SpssFormatAHex && v.Width > 127 {
+			return fmt.Errorf("Cannot use format %s on variable %s, it is wider than 127 bytes", format, v.Name)
+		}
+		return nil
+	}
+
+	if err := validateFormat(format, v.Width, v.Decimal); err != nil {
+		return fmt.Errorf("Invalid format on variable %s: %v", v.Name, err)
+	}
+
+	return nil
+}
+
+// Create the internal variable of a validated variable, the short names are
+// registered in names
+func (v *Variable) newVariable(names map[string]string) (variable, error) {
+	missingCode, missing, missingStrings, err := v.getMissing()
+	if err != nil {
+		return variable{}, err
+	}
+
+	labelValues, err := v.getLabelValues()
+	if err != nil {
+		return variable{}, err
+	}
+
+	attributes, err := v.getAttributes()
+	if err != nil {
+		return variable{}, err
+	}
+
+	shortName := v.getShortName(names)
+
+	return variable{
+		name:         v.Name,
+		shortName:    shortName,
+		segmentNames: v.getSegmentNames(names, shortName),
+		spssType:     v.Type,
+		measure:      v.getMeasure(),
+		decimal:      v.Decimal,
+		width:        v.Width,
+		format:       v.getPrint(),
+		segments:     v.getSegments(),
+		labels:       v.Labels,
+		labelValues:  labelValues,
+		label:        v.Label,
+
+		missingCode:    missingCode,
+		missing:        missing,
+		missingStrings: missingStrings,
+		attributes:     attributes,
+	}, nil
+}
+
 // Create a short name and make sure there are no duplicates
-func (v *Variable) getShortName(s *SpssWriter) string {
+func (v *Variable) getShortName(names map[string]string) string {
 	short := strings.ToUpper(v.Name)
 
 	if len(short) > 8 {
 		short = short[:8]
 	}
 
+	return uniqueShortName(names, short, v.Name)
+}
+
+// Create the short names of every segment, very long strings get a generated
+// name for each additional segment
+func (v *Variable) getSegmentNames(names map[string]string, short string) []string {
+	segmentNames := []string{short}
+
+	for segment := 1; segment < int(v.getSegments()); segment++ {
+		suffix := strconv.Itoa(segment)
+		segmentNames = append(segmentNames, uniqueShortName(names, trim(short, 8-len(suffix))+suffix, v.Name))
+	}
+
+	return segmentNames
+}
+
+// Make sure the short name is not used yet by replacing its tail with a number
+func uniqueShortName(names map[string]string, short string, name string) string {
+	base := short
 	i := 1
 
 	for {
-		_, found := s.names[short]
+		_, found := names[short]
 
 		if !found {
 			break
@@ -108,41 +332,183 @@ func (v *Variable) getShortName(s *SpssWriter) string {
 
 		iString := strconv.Itoa(i)
 
-		short = short[:8-len(iString)] + iString
+		short = trim(base, 8-len(iString)) + iString
 		i++
 	}
 
-	s.names[short] = v.Name
+	names[short] = name
 
 	return short
 }
 
+// Validate the value labels and return the parsed values of numeric labels
+func (v *Variable) getLabelValues() ([]float64, error) {
+	var values []float64
+
+	for _, l := range v.Labels {
+		if v.Type == SpssTypeString {
+			if len(l.Value) > int(v.Width) {
+				return nil, fmt.Errorf("Value %q of label %q on variable %s exceeds the width of %d bytes", l.Value, l.Desc, v.Name, v.Width)
+			}
+			continue
+		}
+
+		f, err := parseNumber(v.getPrint(), l.Value)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid value %q of label %q on variable %s: %v", l.Value, l.Desc, v.Name, err)
+		}
+		values = append(values, f)
+	}
+
+	return values, nil
+}
+
+// Validate the missing values and return n_missing_values with the parsed values
+func (v *Variable) getMissing() (int32, []float64, []string, error) {
+	m := v.MissingValues
+
+	if v.Type == SpssTypeString {
+		if m.Range != nil {
+			return 0, nil, nil, fmt.Errorf("Cannot set a missing range on string variable %s", v.Name)
+		}
+		if len(m.Values) > 3 {
+			return 0, nil, nil, fmt.Errorf("Cannot set %d missing values on variable %s, the maximum is 3", len(m.Values), v.Name)
+		}
+		for _, val := range m.Values {
+			if len(val) > 8 || len(val) > int(v.Width) {
+				return 0, nil, nil, fmt.Errorf("Missing value %q of variable %s exceeds 8 bytes or the variable width", val, v.Name)
+			}
+		}
+		return int32(len(m.Values)), nil, m.Values, nil
+	}
+
+	var values []float64
+	max := 3
+
+	if m.Range != nil {
+		low, err := parseMissingBound(v.getPrint(), m.Range.Low)
+		if err != nil {
+			return 0, nil, nil, fmt.Errorf("Invalid missing range low %q on variable %s: %v", m.Range.Low, v.Name, err)
+		}
+		high, err := parseMissingBound(v.getPrint(), m.Range.High)
+		if err != nil {
+			return 0, nil, nil, fmt.Errorf("Invalid missing range high %q on variable %s: %v", m.Range.High, v.Name, err)
+		}
+		if low > high {
+			return 0, nil, nil, fmt.Errorf("Missing range low %q is greater than high %q on variable %s", m.Range.Low, m.Range.High, v.Name)
+		}
+		values = append(values, low, high)
+		max = 1
+	}
+
+	if len(m.Values) > max {
+		return 0, nil, nil, fmt.Errorf("Cannot set %d missing values on variable %s, the maximum is %d", len(m.Values), v.Name, max)
+	}
+
+	for _, val := range m.Values {
+		f, err := parseNumber(v.getPrint(), val)
+		if err != nil {
+			return 0, nil, nil, fmt.Errorf("Invalid missing value %q on variable %s: %v", val, v.Name, err)
+		}
+		values = append(values, f)
+	}
+
+	if m.Range != nil {
+		return -2 - int32(len(m.Values)), values, nil, nil
+	}
+
+	return int32(len(m.Values)), values, nil, nil
+}
+
+// Validate the custom attributes and role and return them as written in the file
+func (v *Variable) getAttributes() (string, error) {
+	if err := validateAttributes(v.Attributes); err != nil {
+		return "", fmt.Errorf("Invalid attribute on variable %s: %v", v.Name, err)
+	}
+
+	if _, found := v.Attributes["$@Role"]; found {
+		return "", fmt.Errorf("Cannot set attribute $@Role on variable %s, use Role instead", v.Name)
+	}
+
+	if v.Role == "" {
+		return formatAttributes(v.Attributes), nil
+	}
+
+	for code, role := range roleCodes {
+		if strings.ToUpper(string(v.Role)) == string(role) {
+			return formatAttributes(map[string][]string{"$@Role": {strconv.Itoa(code)}}) + formatAttributes(v.Attributes), nil
+		}
+	}
+
+	return "", fmt.Errorf("Unknown role %s on variable %s", v.Role, v.Name)
+}
+
+// Storage width of a segment, very long strings are split in segments of 255
+// bytes of which 252 are used, the last segment holds the remainder
 func (v *variable) segmentWidth(index int) int32 {
-	if v.spssType == SpssTypeString {
-		return 40
+	if v.spssType != SpssTypeString {
+		return 0
 	}
 
-	return 0
+	if v.segments == 1 {
+		return int32(v.width)
+	}
+
+	if index < int(v.segments)-1 {
+		return 255
+	}
+
+	return int32(v.width) - int32(index)*252
+}
+
+// Storage of the variable in a case, used to read the cases
+func (v *Variable) layout() variable {
+	return variable{name: v.Name, spssType: v.Type, width: v.Width, format: v.getPrint(), segments: v.getSegments()}
 }
 
 func (v *Variable) getSegments() int16 {
+	if v.Type == SpssTypeString && v.Width > 255 {
+		return int16((int(v.Width) + 251) / 252)
+	}
+
 	return 1
 }
 
-func (v *Variable) getPrint() int8 {
+func (v *Variable) getPrint() SpssFormat {
+	if v.Format != 0 {
+		return v.Format
+	}
+
 	switch v.Type {
-	case SpssTypeNumeric:
-		return 5
 	case SpssTypeDate:
-		return 20
+		return SpssFormatDate
 	case SpssTypeDatetime:
-		return 22
-	default: // string
-		return 1
+		return SpssFormatDatetime
+	case SpssTypeString:
+		return SpssFormatA
+	default:
+		return SpssFormatF
 	}
 }
 
 func (v *Variable) setDefaultWidth() error {
+	if v.Format != 0 && v.Type != SpssTypeString {
+		info := formats[v.Format]
+		switch info.family {
+		case familyNumber:
+			v.Width = info.defaultWidth + int16(v.Decimal)
+		case familyTime, familyDatetime:
+			if v.Decimal > 0 {
+				v.Width = info.defaultWidth + 1 + int16(v.Decimal)
+			} else {
+				v.Width = info.defaultWidth
+			}
+		default:
+			v.Width = info.defaultWidth
+		}
+		return nil
+	}
+
 	switch v.Type {
 	case SpssTypeDate:
 		v.Decimal = 0
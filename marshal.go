@@ -0,0 +1,253 @@
+package gospss
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// Struct field that is stored in a variable
+type structField struct {
+	index    int          // Index of the field in the struct
+	pointer  bool         // Field is a pointer, nil is missing
+	kind     reflect.Kind // Kind of the field or the element it points to
+	variable Variable     // Variable of the field
+}
+
+// Encoder writes structs as cases, the variables are taken from the fields and their
+// spss tags, e.g. `spss:"AGE,label=Respondent age,measure=scale,width=3"`. String fields
+// without a width get 40 characters, longer values are cut off.
+type Encoder struct {
+	writer *SpssWriter   // Writer of the file
+	typ    reflect.Type  // Type of the struct, nil until the variables are added
+	fields []structField // Fields stored in variables
+}
+
+// NewEncoder - Returns an encoder that writes to the given SpssWriter, the caller still
+// has to call Finish on the writer
+func NewEncoder(w *SpssWriter) *Encoder {
+	return &Encoder{writer: w}
+}
+
+// Marshal - Write a slice of structs, or pointers to structs, as an SPSS file
+func Marshal(w io.WriteSeeker, rows interface{}, options ...WriterOption) error {
+	v := reflect.ValueOf(rows)
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		return fmt.Errorf("Cannot marshal %T, expected a slice of structs", rows)
+	}
+
+	spssWriter, err := NewSpssWriter(w, options...)
+	if err != nil {
+		return err
+	}
+
+	e := NewEncoder(spssWriter)
+	if err := e.addVariables(v.Type().Elem()); err != nil {
+		return err
+	}
+
+	for i := 0; i < v.Len(); i++ {
+		if err := e.Encode(v.Index(i).Interface()); err != nil {
+			return err
+		}
+	}
+
+	return spssWriter.Finish()
+}
+
+// Encode - Write a struct, or a pointer to a struct, as a case. The variables are added on
+// the first call, every later call must pass the same type
+func (e *Encoder) Encode(row interface{}) error {
+	v := reflect.ValueOf(row)
+	if !v.IsValid() || v.Kind() == reflect.Ptr && v.IsNil() {
+		return fmt.Errorf("Cannot encode nil")
+	}
+	if v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+
+	if e.typ == nil {
+		if err := e.addVariables(v.Type()); err != nil {
+			return err
+		}
+	}
+
+	if v.Type() != e.typ {
+		return fmt.Errorf("Cannot encode %s, the encoder writes %s", v.Type(), e.typ)
+	}
+
+	values := make(Row, len(e.fields))
+	for _, f := range e.fields {
+		values[f.variable.Name] = f.datum(v.Field(f.index))
+	}
+
+	return e.writer.AddRow(values)
+}
+
+func (e *Encoder) addVariables(t reflect.Type) error {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	fields, err := structFields(t)
+	if err != nil {
+		return err
+	}
+
+	for i := range fields {
+		if err := e.writer.AddVariable(&fields[i].variable); err != nil {
+			return err
+		}
+	}
+
+	e.typ = t
+	e.fields = fields
+	return nil
+}
+
+// Returns the fields of a struct with their variables, fields that are not exported
+// or tagged with spss:"-" are left out
+func structFields(t reflect.Type) ([]structField, error) {
+	if t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("Cannot use %s, expected a struct", t)
+	}
+
+	var fields []structField
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		tag := sf.Tag.Get("spss")
+		if sf.PkgPath != "" || tag == "-" {
+			continue
+		}
+
+		f := structField{index: i}
+		ft := sf.Type
+		if ft.Kind() == reflect.Ptr {
+			f.pointer = true
+			ft = ft.Elem()
+		}
+		f.kind = ft.Kind()
+
+		switch {
+		case ft == timeType:
+			f.variable.Type = SpssTypeDatetime
+		case f.kind == reflect.String:
+			f.variable.Type = SpssTypeString
+		case f.kind == reflect.Float32 || f.kind == reflect.Float64:
+			f.variable.Type = SpssTypeNumeric
+			f.variable.Decimal = 2
+		case f.kind == reflect.Bool:
+			f.variable.Type = SpssTypeNumeric
+			f.variable.Width = 1
+		case f.kind >= reflect.Int && f.kind <= reflect.Uint64:
+			f.variable.Type = SpssTypeNumeric
+		default:
+			return nil, fmt.Errorf("Cannot use field %s of type %s", sf.Name, sf.Type)
+		}
+
+		if err := parseTag(sf.Name, tag, &f.variable); err != nil {
+			return nil, err
+		}
+
+		fields = append(fields, f)
+	}
+
+	return fields, nil
+}
+
+// Set the variable from a tag like "AGE,label=Respondent age,measure=scale,width=3",
+// the name of the field is used when the tag has no name
+func parseTag(field string, tag string, V *Variable) error {
+	parts := strings.Split(tag, ",")
+	decimal := false
+
+	V.Name = parts[0]
+	if V.Name == "" {
+		V.Name = field
+	}
+
+	for _, part := range parts[1:] {
+		kv := strings.SplitN(part, "=", 2)
+		key := strings.TrimSpace(kv[0])
+		val := ""
+		if len(kv) == 2 {
+			val = kv[1]
+		}
+
+		switch key {
+		case "label":
+			V.Label = val
+		case "measure":
+			switch m := SpssMeasure(strings.ToUpper(val)); m {
+			case SpssMeasureNominal, SpssMeasureOrdinal, SpssMeasureScale:
+				V.Measure = m
+			default:
+				return fmt.Errorf("Invalid measure %s on field %s", val, field)
+			}
+		case "width":
+			w, err := strconv.ParseInt(val, 10, 16)
+			if err != nil {
+				return fmt.Errorf("Invalid width %s on field %s", val, field)
+			}
+			V.Width = int16(w)
+		case "decimal":
+			d, err := strconv.ParseInt(val, 10, 8)
+			if err != nil {
+				return fmt.Errorf("Invalid decimal %s on field %s", val, field)
+			}
+			V.Decimal = int8(d)
+			decimal = true
+		case "date":
+			if V.Type != SpssTypeDatetime {
+				return fmt.Errorf("Cannot use date on field %s, it is not a time.Time", field)
+			}
+			V.Type = SpssTypeDate
+		default:
+			return fmt.Errorf("Unknown option %s on field %s", key, field)
+		}
+	}
+
+	// Drop the default decimals of floats when the width is too small for them
+	if !decimal && V.Width > 0 && V.Width <= int16(V.Decimal) {
+		V.Decimal = 0
+	}
+
+	return nil
+}
+
+// Value of the field, nil pointers and zero times are system-missing
+func (f *structField) datum(v reflect.Value) Datum {
+	if f.pointer {
+		if v.IsNil() {
+			return SystemMissing()
+		}
+		v = v.Elem()
+	}
+
+	switch {
+	case f.variable.Type == SpssTypeDate || f.variable.Type == SpssTypeDatetime:
+		t := v.Interface().(time.Time)
+		if t.IsZero() {
+			return SystemMissing()
+		}
+		return Time(t)
+	case f.kind == reflect.String:
+		return Text(v.String())
+	case f.kind == reflect.Bool:
+		if v.Bool() {
+			return Number(1)
+		}
+		return Number(0)
+	case f.kind == reflect.Float32 || f.kind == reflect.Float64:
+		return Number(v.Float())
+	case f.kind >= reflect.Int && f.kind <= reflect.Int64:
+		return Number(float64(v.Int()))
+	default:
+		return Number(float64(v.Uint()))
+	}
+}
@@ -0,0 +1,250 @@
+package gospss
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testPerson struct {
+	ID      int       `spss:"ID,measure=nominal"`
+	Age     *int      `spss:"AGE,label=Respondent age,measure=scale,width=3"`
+	Name    string    `spss:"NAME,width=20"`
+	Score   float64   `spss:",decimal=3"`
+	Ratio   float32   `spss:"RATIO,width=2"`
+	Member  bool      `spss:"MEMBER"`
+	Visits  uint16    `spss:"VISITS"`
+	Birth   time.Time `spss:"BIRTH,date"`
+	Seen    *time.Time
+	Comment string
+	Notes   string `spss:"-"`
+	secret  string
+}
+
+func testPeople() []testPerson {
+	age := 42
+	seen := time.Date(2021, 3, 14, 15, 9, 26, 0, time.UTC)
+
+	return []testPerson{
+		{
+			ID:      1,
+			Age:     &age,
+			Name:    "John",
+			Score:   1.125,
+			Ratio:   0.5,
+			Member:  true,
+			Visits:  65535,
+			Birth:   time.Date(1979, 5, 27, 0, 0, 0, 0, time.UTC),
+			Seen:    &seen,
+			Comment: strings.Repeat("x", 50),
+			Notes:   "Not written",
+			secret:  "Not written",
+		},
+		{ID: 2},
+	}
+}
+
+func TestParseTag(t *testing.T) {
+	tests := []struct {
+		tag  string
+		typ  SpssType
+		want Variable
+	}{
+		{"", SpssTypeNumeric, Variable{Name: "Field", Type: SpssTypeNumeric}},
+		{",width=3", SpssTypeNumeric, Variable{Name: "Field", Type: SpssTypeNumeric, Width: 3}},
+		{"AGE", SpssTypeNumeric, Variable{Name: "AGE", Type: SpssTypeNumeric}},
+		{"AGE,label=Respondent age", SpssTypeNumeric, Variable{Name: "AGE", Type: SpssTypeNumeric, Label: "Respondent age"}},
+		{"AGE,label=a=b", SpssTypeNumeric, Variable{Name: "AGE", Type: SpssTypeNumeric, Label: "a=b"}},
+		{"AGE,measure=nominal", SpssTypeNumeric, Variable{Name: "AGE", Type: SpssTypeNumeric, Measure: SpssMeasureNominal}},
+		{"AGE,measure=Ordinal", SpssTypeNumeric, Variable{Name: "AGE", Type: SpssTypeNumeric, Measure: SpssMeasureOrdinal}},
+		{"AGE, measure=SCALE", SpssTypeNumeric, Variable{Name: "AGE", Type: SpssTypeNumeric, Measure: SpssMeasureScale}},
+		{"AGE,width=3,decimal=1", SpssTypeNumeric, Variable{Name: "AGE", Type: SpssTypeNumeric, Width: 3, Decimal: 1}},
+		{"BIRTH,date", SpssTypeDatetime, Variable{Name: "BIRTH", Type: SpssTypeDate}},
+		{"SEEN", SpssTypeDatetime, Variable{Name: "SEEN", Type: SpssTypeDatetime}},
+	}
+
+	for _, tt := range tests {
+		V := Variable{Type: tt.typ}
+		if err := parseTag("Field", tt.tag, &V); err != nil {
+			t.Errorf("parseTag(%q) failed: %v", tt.tag, err)
+			continue
+		}
+		if V.Name != tt.want.Name || V.Type != tt.want.Type || V.Label != tt.want.Label || V.Measure != tt.want.Measure ||
+			V.Width != tt.want.Width || V.Decimal != tt.want.Decimal {
+			t.Errorf("parseTag(%q) = %+v, want %+v", tt.tag, V, tt.want)
+		}
+	}
+}
+
+func TestParseTagInvalid(t *testing.T) {
+	tests := []struct {
+		tag string
+		err string
+	}{
+		{"AGE,size=3", "Unknown option size on field Field"},
+		{"AGE,measure=interval", "Invalid measure interval on field Field"},
+		{"AGE,width=wide", "Invalid width wide on field Field"},
+		{"AGE,width=40000", "Invalid width 40000 on field Field"},
+		{"AGE,decimal=", "Invalid decimal  on field Field"},
+		{"AGE,date", "Cannot use date on field Field, it is not a time.Time"},
+	}
+
+	for _, tt := range tests {
+		V := Variable{Type: SpssTypeNumeric}
+		err := parseTag("Field", tt.tag, &V)
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("parseTag(%q) error = %v, want it to contain %q", tt.tag, err, tt.err)
+		}
+	}
+}
+
+func TestStructFields(t *testing.T) {
+	fields, err := structFields(reflect.TypeOf(testPerson{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Variable{
+		{Name: "ID", Type: SpssTypeNumeric, Measure: SpssMeasureNominal},
+		{Name: "AGE", Type: SpssTypeNumeric, Label: "Respondent age", Measure: SpssMeasureScale, Width: 3},
+		{Name: "NAME", Type: SpssTypeString, Width: 20},
+		{Name: "Score", Type: SpssTypeNumeric, Decimal: 3},
+		{Name: "RATIO", Type: SpssTypeNumeric, Width: 2},
+		{Name: "MEMBER", Type: SpssTypeNumeric, Width: 1},
+		{Name: "VISITS", Type: SpssTypeNumeric},
+		{Name: "BIRTH", Type: SpssTypeDate},
+		{Name: "Seen", Type: SpssTypeDatetime},
+		{Name: "Comment", Type: SpssTypeString},
+	}
+	if len(fields) != len(want) {
+		t.Fatalf("structFields() returned %d fields, want %d", len(fields), len(want))
+	}
+	for i, f := range fields {
+		V := f.variable
+		if V.Name != want[i].Name || V.Type != want[i].Type || V.Label != want[i].Label || V.Measure != want[i].Measure ||
+			V.Width != want[i].Width || V.Decimal != want[i].Decimal {
+			t.Errorf("Field %d = %+v, want %+v", i, V, want[i])
+		}
+	}
+
+	for _, v := range []interface{}{struct{ M map[string]int }{}, struct{ S []int }{}, struct{ C complex128 }{}} {
+		if _, err := structFields(reflect.TypeOf(v)); err == nil {
+			t.Errorf("structFields(%T) succeeded, want an error", v)
+		}
+	}
+	if _, err := structFields(reflect.TypeOf(1)); err == nil {
+		t.Error("structFields(int) succeeded, want an error")
+	}
+}
+
+func TestMarshal(t *testing.T) {
+	file := &memFile{}
+	if err := Marshal(file, testPeople()); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewSpssReader(bytes.NewReader(file.data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	widths := map[string]int16{"AGE": 3, "NAME": 20, "RATIO": 2, "MEMBER": 1, "Comment": 40}
+	for _, V := range r.Variables() {
+		if w, found := widths[V.Name]; found && V.Width != w {
+			t.Errorf("Variable %s has width %d, want %d", V.Name, V.Width, w)
+		}
+		if V.Name == "Score" && V.Decimal != 3 {
+			t.Errorf("Variable Score has %d decimals, want 3", V.Decimal)
+		}
+		if V.Name == "BIRTH" && V.Type != SpssTypeDate || V.Name == "Seen" && V.Type != SpssTypeDatetime {
+			t.Errorf("Variable %s has type %s", V.Name, V.Type)
+		}
+	}
+
+	cases := r.Cases()
+	if !cases.Next() {
+		t.Fatal(cases.Err())
+	}
+	c := cases.Row()
+	for name, want := range map[string]float64{"ID": 1, "AGE": 42, "Score": 1.125, "RATIO": 0.5, "MEMBER": 1, "VISITS": 65535} {
+		if got, ok := c.Float(name); !ok || got != want {
+			t.Errorf("Case 1 has %s = %v, want %v", name, got, want)
+		}
+	}
+	if got, _ := c.Text("Comment"); got != strings.Repeat("x", 40) {
+		t.Errorf("Case 1 has Comment = %q, want it cut off at 40 characters", got)
+	}
+	if got, _ := c.Time("Seen"); !got.Equal(time.Date(2021, 3, 14, 15, 9, 26, 0, time.UTC)) {
+		t.Errorf("Case 1 has Seen = %v", got)
+	}
+
+	// Nil pointers and zero times are system-missing, false is 0
+	if !cases.Next() {
+		t.Fatal(cases.Err())
+	}
+	c = cases.Row()
+	for _, name := range []string{"AGE", "BIRTH", "Seen"} {
+		if !c.IsMissing(name) {
+			t.Errorf("Case 2 has %s = %v, want system-missing", name, c.numbers[c.lookup[name]])
+		}
+	}
+	if got, ok := c.Float("MEMBER"); !ok || got != 0 {
+		t.Errorf("Case 2 has MEMBER = %v, want 0", got)
+	}
+
+	if cases.Next() {
+		t.Error("Read more cases than written")
+	}
+	if err := cases.Err(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMarshalInvalid(t *testing.T) {
+	if err := Marshal(&memFile{}, testPerson{}); err == nil {
+		t.Error("Marshal() accepted a struct instead of a slice")
+	}
+	if err := Marshal(&memFile{}, []int{1}); err == nil {
+		t.Error("Marshal() accepted a slice of ints")
+	}
+	if err := Marshal(&memFile{}, []struct {
+		A int `spss:"A,colour=red"`
+	}{{1}}); err == nil {
+		t.Error("Marshal() accepted an unknown tag option")
+	}
+}
+
+func TestEncoder(t *testing.T) {
+	spssWriter, err := NewSpssWriter(&memFile{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoder := NewEncoder(spssWriter)
+
+	if err := encoder.Encode(nil); err == nil {
+		t.Error("Encode(nil) succeeded")
+	}
+	if err := encoder.Encode((*testPerson)(nil)); err == nil {
+		t.Error("Encode() of a typed nil pointer succeeded")
+	}
+
+	people := testPeople()
+	if err := encoder.Encode(people[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err := encoder.Encode(&people[1]); err != nil {
+		t.Fatal(err)
+	}
+	if err := encoder.Encode(struct{ ID int }{1}); err == nil {
+		t.Error("Encode() of another type succeeded")
+	}
+
+	if err := spssWriter.Finish(); err != nil {
+		t.Fatal(err)
+	}
+	if spssWriter.valCount != 2 {
+		t.Errorf("Wrote %d cases, want 2", spssWriter.valCount)
+	}
+}
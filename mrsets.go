@@ -0,0 +1,181 @@
+package gospss
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// SpssMRSetType declares the types of multiple response sets
+type SpssMRSetType string
+
+const (
+	// SpssMRSetDichotomy is a set of variables that each count one value, like 1 for selected
+	SpssMRSetDichotomy SpssMRSetType = "DICHOTOMY"
+	// SpssMRSetCategory is a set of variables that hold the categories that were chosen
+	SpssMRSetCategory SpssMRSetType = "CATEGORY"
+)
+
+// MultipleResponseSet defines a set of existing variables that SPSS analyses as one
+// multi-select question
+type MultipleResponseSet struct {
+	Name         string        // Name of the set, $ is added when missing
+	Type         SpssMRSetType // Dichotomy or category set
+	Label        string        // Label of the set
+	CountedValue string        // Value that counts as selected in a dichotomy set
+	Variables    []string      // Names of the variables in the set
+
+	// Use the value labels of the counted value as category labels instead of the
+	// variable labels, only for dichotomy sets
+	CountedValueLabels bool
+}
+
+// Check the set against the variables of the writer and return it as written in the file
+func (s *SpssWriter) formatMRSet(set *MultipleResponseSet) (string, error) {
+	name := set.Name
+	if !strings.HasPrefix(name, "$") {
+		name = "$" + name
+	}
+	if len(name) > 64 || !nameValidatorRegex.MatchString(name[1:]) {
+		return "", fmt.Errorf("Invalid name %s of multiple response set", set.Name)
+	}
+
+	if strings.Contains(set.Label, "\n") {
+		return "", fmt.Errorf("Label of multiple response set %s cannot contain a line feed", name)
+	}
+
+	if len(set.Variables) < 2 {
+		return "", fmt.Errorf("Multiple response set %s needs at least two variables", name)
+	}
+
+	var first *variable
+	for _, n := range set.Variables {
+		i, found := s.lookup[n]
+		if !found {
+			return "", fmt.Errorf("Cannot add variable %s to multiple response set %s, it does not exist", n, name)
+		}
+		v := &s.variables[i]
+		if first == nil {
+			first = v
+		} else if (v.spssType == SpssTypeString) != (first.spssType == SpssTypeString) {
+			return "", fmt.Errorf("Variables of multiple response set %s must all be numeric or all be strings", name)
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString(name + "=")
+
+	switch set.Type {
+	case SpssMRSetCategory:
+		if set.CountedValueLabels {
+			return "", fmt.Errorf("Cannot use counted value labels on category set %s", name)
+		}
+		b.WriteString("C ")
+	case SpssMRSetDichotomy:
+		counted := set.CountedValue
+		if first.spssType == SpssTypeString {
+			for _, n := range set.Variables {
+				if v := s.variables[s.lookup[n]]; len(counted) > int(v.width) {
+					return "", fmt.Errorf("Counted value %q of multiple response set %s exceeds the width of variable %s", counted, name, n)
+				}
+			}
+		} else {
+			f, err := strconv.ParseFloat(counted, 64)
+			if err != nil || f != math.Trunc(f) {
+				return "", fmt.Errorf("Counted value %q of multiple response set %s must be an integer", counted, name)
+			}
+			counted = strconv.FormatFloat(f, 'f', 0, 64)
+		}
+		if counted == "" {
+			return "", fmt.Errorf("Multiple response set %s needs a counted value", name)
+		}
+
+		if set.CountedValueLabels {
+			b.WriteString("E 1 ")
+		} else {
+			b.WriteString("D")
+		}
+		b.WriteString(strconv.Itoa(len(counted)) + " " + counted + " ")
+	default:
+		return "", fmt.Errorf("Unknown type %s of multiple response set %s", set.Type, name)
+	}
+
+	b.WriteString(strconv.Itoa(len(set.Label)) + " " + set.Label)
+
+	// SPSS lists the variables by their short names in lower case
+	for _, n := range set.Variables {
+		b.WriteString(" " + strings.ToLower(s.variables[s.lookup[n]].shortName))
+	}
+	b.WriteByte('\n')
+
+	return b.String(), nil
+}
+
+// Parse the sets of extension records 7 and 19, the variables keep their short names
+// until the long names are known
+func parseMRSets(data string) ([]MultipleResponseSet, error) {
+	var sets []MultipleResponseSet
+
+	for _, line := range strings.Split(data, "\n") {
+		if line == "" {
+			continue
+		}
+
+		eq := strings.IndexByte(line, '=')
+		if eq < 0 {
+			return nil, fmt.Errorf("Missing = in multiple response set %q", line)
+		}
+		set := MultipleResponseSet{Name: line[:eq]}
+		rest := line[eq+1:]
+
+		// Read a length followed by a space and that many bytes
+		counted := func() (string, bool) {
+			space := strings.IndexByte(rest, ' ')
+			if space < 0 {
+				return "", false
+			}
+			n, err := strconv.Atoi(rest[:space])
+			if err != nil || n < 0 || space+1+n > len(rest) {
+				return "", false
+			}
+			val := rest[space+1 : space+1+n]
+			rest = rest[space+1+n:]
+			return val, true
+		}
+
+		var ok bool
+		switch {
+		case strings.HasPrefix(rest, "C "):
+			set.Type = SpssMRSetCategory
+			rest = rest[2:]
+			ok = true
+		case strings.HasPrefix(rest, "D"):
+			set.Type = SpssMRSetDichotomy
+			rest = rest[1:]
+			set.CountedValue, ok = counted()
+			rest = strings.TrimPrefix(rest, " ")
+		case strings.HasPrefix(rest, "E "):
+			set.Type = SpssMRSetDichotomy
+			set.CountedValueLabels = true
+			fields := strings.SplitN(rest[2:], " ", 2)
+			if len(fields) == 2 {
+				rest = fields[1]
+				set.CountedValue, ok = counted()
+				rest = strings.TrimPrefix(rest, " ")
+			}
+		}
+		if !ok {
+			return nil, fmt.Errorf("Invalid multiple response set %q", line)
+		}
+
+		if set.Label, ok = counted(); !ok {
+			return nil, fmt.Errorf("Invalid label of multiple response set %q", line)
+		}
+		set.Variables = strings.Fields(rest)
+
+		sets = append(sets, set)
+	}
+
+	return sets, nil
+}
@@ -0,0 +1,146 @@
+package gospss
+
+import (
+	"strings"
+	"testing"
+)
+
+// Returns a writer with numeric and string variables to build sets from
+func newTestMRSetWriter(t *testing.T) *SpssWriter {
+	t.Helper()
+
+	spssWriter, err := NewSpssWriter(&memFile{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, V := range []Variable{
+		{Name: "BRAND_A_LONG", Type: SpssTypeNumeric},
+		{Name: "BRAND_B_LONG", Type: SpssTypeNumeric},
+		{Name: "Q1", Type: SpssTypeNumeric},
+		{Name: "S1", Type: SpssTypeString, Width: 3},
+		{Name: "S2", Type: SpssTypeString, Width: 5},
+	} {
+		V := V
+		if err := spssWriter.AddVariable(&V); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return spssWriter
+}
+
+func TestFormatMRSet(t *testing.T) {
+	tests := []struct {
+		name string
+		set  MultipleResponseSet
+		want string
+	}{
+		{
+			"dichotomy",
+			MultipleResponseSet{Name: "brands", Type: SpssMRSetDichotomy, Label: "Brands", CountedValue: "1",
+				Variables: []string{"BRAND_A_LONG", "BRAND_B_LONG"}},
+			"$brands=D1 1 6 Brands brand_a_ brand_b_\n",
+		},
+		{
+			"counted value labels",
+			MultipleResponseSet{Name: "$brands", Type: SpssMRSetDichotomy, CountedValue: "1.0", CountedValueLabels: true,
+				Variables: []string{"BRAND_A_LONG", "Q1"}},
+			"$brands=E 1 1 1 0  brand_a_ q1\n",
+		},
+		{
+			"string dichotomy",
+			MultipleResponseSet{Name: "text", Type: SpssMRSetDichotomy, CountedValue: "yes", Variables: []string{"S1", "S2"}},
+			"$text=D3 yes 0  s1 s2\n",
+		},
+		{
+			"category",
+			MultipleResponseSet{Name: "cat", Type: SpssMRSetCategory, Label: "Categories", Variables: []string{"S1", "S2"}},
+			"$cat=C 10 Categories s1 s2\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := newTestMRSetWriter(t).formatMRSet(&tt.set)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("formatMRSet() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAddMultipleResponseSetInvalid(t *testing.T) {
+	numbers := []string{"BRAND_A_LONG", "BRAND_B_LONG"}
+
+	tests := []struct {
+		name string
+		set  MultipleResponseSet
+		err  string
+	}{
+		{"bad name", MultipleResponseSet{Name: "1st", Type: SpssMRSetCategory, Variables: numbers}, "Invalid name 1st"},
+		{"line feed in label", MultipleResponseSet{Name: "set", Type: SpssMRSetCategory, Label: "a\nb", Variables: numbers},
+			"cannot contain a line feed"},
+		{"no variables", MultipleResponseSet{Name: "set", Type: SpssMRSetCategory}, "needs at least two variables"},
+		{"single variable", MultipleResponseSet{Name: "set", Type: SpssMRSetCategory, Variables: []string{"Q1"}},
+			"needs at least two variables"},
+		{"unknown variable", MultipleResponseSet{Name: "set", Type: SpssMRSetCategory, Variables: []string{"Q1", "Q2"}},
+			"Cannot add variable Q2 to multiple response set $set"},
+		{"mixed types", MultipleResponseSet{Name: "set", Type: SpssMRSetCategory, Variables: []string{"Q1", "S1"}},
+			"must all be numeric or all be strings"},
+		{"unknown type", MultipleResponseSet{Name: "set", Type: "MULTIPLE", Variables: numbers}, "Unknown type MULTIPLE"},
+		{"counted value labels on category set",
+			MultipleResponseSet{Name: "set", Type: SpssMRSetCategory, CountedValueLabels: true, Variables: numbers},
+			"Cannot use counted value labels on category set $set"},
+		{"missing counted value", MultipleResponseSet{Name: "set", Type: SpssMRSetDichotomy, Variables: []string{"S1", "S2"}},
+			"needs a counted value"},
+		{"non-integer counted value",
+			MultipleResponseSet{Name: "set", Type: SpssMRSetDichotomy, CountedValue: "1.5", Variables: numbers},
+			"must be an integer"},
+		{"non-numeric counted value",
+			MultipleResponseSet{Name: "set", Type: SpssMRSetDichotomy, CountedValue: "yes", Variables: numbers},
+			"must be an integer"},
+		{"counted value too wide",
+			MultipleResponseSet{Name: "set", Type: SpssMRSetDichotomy, CountedValue: "four", Variables: []string{"S2", "S1"}},
+			"exceeds the width of variable S1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := newTestMRSetWriter(t).AddMultipleResponseSet(tt.set)
+			if err == nil {
+				t.Fatalf("AddMultipleResponseSet() succeeded, want error containing %q", tt.err)
+			}
+			if !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("AddMultipleResponseSet() error = %q, want it to contain %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestAddMultipleResponseSetDuplicate(t *testing.T) {
+	spssWriter := newTestMRSetWriter(t)
+
+	set := MultipleResponseSet{Name: "brands", Type: SpssMRSetCategory, Variables: []string{"BRAND_A_LONG", "BRAND_B_LONG"}}
+	if err := spssWriter.AddMultipleResponseSet(set); err != nil {
+		t.Fatal(err)
+	}
+
+	// The $ is added to the name, so both spellings are the same set
+	for _, name := range []string{"brands", "$brands"} {
+		set.Name = name
+		err := spssWriter.AddMultipleResponseSet(set)
+		if err == nil || !strings.Contains(err.Error(), "already exists") {
+			t.Errorf("AddMultipleResponseSet(%s) error = %v, want an error about a duplicate", name, err)
+		}
+	}
+
+	if err := spssWriter.AddValueRow(map[string]string{"Q1": "1"}); err != nil {
+		t.Fatal(err)
+	}
+	set.Name = "late"
+	if err := spssWriter.AddMultipleResponseSet(set); err == nil {
+		t.Error("AddMultipleResponseSet() succeeded after values are written")
+	}
+}
@@ -0,0 +1,426 @@
+package gospss
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Translation table of the portable character set, the position of a character
+// is its portable code and unused positions are filled with zeros
+const porCharset = "0000000000000000000000000000000000000000000000000000000000000000" +
+	"0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz ." +
+	"<(+|&[]!$*);^-/|,%_>?`:#@'=\"000000~-0000123456789000-()0{}\\00000" +
+	"0000000000000000000000000000000000000000000000000000000000000000"
+
+// Number of base 30 digits used for numbers that are not integers
+const porPrecision = 11
+
+// porLineWriter breaks the output in lines of 80 characters
+type porLineWriter struct {
+	io.Writer
+	column int
+}
+
+func (w *porLineWriter) Write(p []byte) (int, error) {
+	n := 0
+	for len(p) > 0 {
+		c := 80 - w.column
+		if c > len(p) {
+			c = len(p)
+		}
+
+		m, err := w.Writer.Write(p[:c])
+		n += m
+		if err != nil {
+			return n, err
+		}
+
+		w.column += c
+		p = p[c:]
+
+		if w.column == 80 {
+			if _, err := w.Writer.Write([]byte("\r\n")); err != nil {
+				return n, err
+			}
+			w.column = 0
+		}
+	}
+	return n, nil
+}
+
+// PorWriter defines the struct to write SPSS portable files
+type PorWriter struct {
+	writer    *bufio.Writer     // Buffered writer
+	lines     *porLineWriter    // Breaks the output in lines
+	names     map[string]string // Mapping of short names
+	lookup    map[string]int    // Position of each variable in variables
+	variables []variable        // Variables in declaration order
+	documents []string          // Document lines
+	started   bool              // Dictionary is written
+	err       error             // First write error, returned by every later call
+	now       func() time.Time  // Clock for the creation date and time
+}
+
+// PorWriterOption configures a PorWriter before anything is written
+type PorWriterOption func(*PorWriter) error
+
+// WithPorClock - Set the function that returns the creation date and time of the portable
+// file, a fixed time makes the output reproducible
+func WithPorClock(now func() time.Time) PorWriterOption {
+	return func(p *PorWriter) error {
+		if now == nil {
+			return fmt.Errorf("Clock cannot be nil")
+		}
+		p.now = now
+		return nil
+	}
+}
+
+// NewPorWriter - Returns a portable file writer given a file or any other io.Writer,
+// the dictionary is written with the first row
+func NewPorWriter(w io.Writer, options ...PorWriterOption) (*PorWriter, error) {
+	lines := &porLineWriter{Writer: w}
+
+	porWriter := &PorWriter{
+		writer: bufio.NewWriter(lines),
+		lines:  lines,
+		names:  make(map[string]string),
+		lookup: make(map[string]int),
+		now:    time.Now,
+	}
+
+	for _, option := range options {
+		if err := option(porWriter); err != nil {
+			return nil, err
+		}
+	}
+
+	return porWriter, nil
+}
+
+// AddVariable - Add variables to the portable file
+// CAUTION: Once values are being written you cannot add any more variables
+func (p *PorWriter) AddVariable(V *Variable) error {
+	if p.err != nil {
+		return p.err
+	}
+
+	if p.started {
+		return fmt.Errorf("Cannot add variable %s after values are written", V.Name)
+	}
+
+	if err := V.validate(); err != nil {
+		return err
+	}
+
+	if V.Type == SpssTypeString && V.Width > 255 {
+		return fmt.Errorf("Cannot set width of %d on variable %s, portable files support strings up to 255 bytes", V.Width, V.Name)
+	}
+
+	// Check if name already exists (duplicate)
+	if _, exists := p.lookup[V.Name]; exists {
+		return fmt.Errorf("Cannot add variable with name %s since it already exists", V.Name)
+	}
+
+	v, err := V.newVariable(p.names)
+	if err != nil {
+		return err
+	}
+
+	p.lookup[v.name] = len(p.variables)
+	p.variables = append(p.variables, v)
+
+	return nil
+}
+
+// SetDocuments - Set the lines of the document record, lines are cut at 80 characters
+// CAUTION: Documents must be set before adding values
+func (p *PorWriter) SetDocuments(lines []string) error {
+	if p.err != nil {
+		return p.err
+	}
+
+	if p.started {
+		return fmt.Errorf("Cannot set documents after values are written")
+	}
+
+	p.documents = nil
+	for _, line := range lines {
+		if err := p.AddDocument(line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AddDocument - Add a line to the document record, the line is cut at 80 characters
+// CAUTION: Documents must be added before adding values
+func (p *PorWriter) AddDocument(line string) error {
+	if p.err != nil {
+		return p.err
+	}
+
+	if p.started {
+		return fmt.Errorf("Cannot add document after values are written")
+	}
+
+	p.documents = append(p.documents, trim(line, 80))
+	return nil
+}
+
+// AddValueRow - Add a row of values to the portable file
+// CAUTION: All variables must be written before adding values
+func (p *PorWriter) AddValueRow(values map[string]string) error {
+	if p.err != nil {
+		return p.err
+	}
+
+	if !p.started {
+		p.writeDictionary()
+	}
+
+	for _, v := range p.variables {
+		val := values[v.name]
+
+		if v.spssType == SpssTypeString {
+			// Trailing spaces are left out but a value has at least one character
+			val = strings.TrimRight(trim(val, int(v.width)), " ")
+			if val == "" {
+				val = " "
+			}
+			p.writeString(val)
+			continue
+		}
+
+		f, err := parseNumber(v.format, val)
+		if err != nil {
+			f = sysmis
+		}
+		p.writeNumber(f)
+	}
+
+	return p.err
+}
+
+// Finish - Execute this once all variables and values are written to complete the file,
+// returns the first error that occurred while writing
+func (p *PorWriter) Finish() error {
+	if !p.started {
+		p.writeDictionary()
+	}
+
+	// The file ends with a Z, the last line is filled up with more of them
+	p.write("Z")
+	p.flush()
+	if p.lines.column > 0 {
+		p.write(strings.Repeat("Z", 80-p.lines.column))
+	}
+	p.flush()
+
+	return p.err
+}
+
+// Keep the first error that occurred
+func (p *PorWriter) setErr(err error) {
+	if p.err == nil && err != nil {
+		p.err = err
+	}
+}
+
+func (p *PorWriter) write(s string) {
+	if p.err == nil {
+		_, err := p.writer.WriteString(s)
+		p.setErr(err)
+	}
+}
+
+func (p *PorWriter) flush() {
+	if p.err == nil {
+		p.setErr(p.writer.Flush())
+	}
+}
+
+func (p *PorWriter) writeInt(i int) {
+	p.write(strings.ToUpper(strconv.FormatInt(int64(i), 30)) + "/")
+}
+
+func (p *PorWriter) writeNumber(f float64) {
+	p.write(porNumber(f))
+}
+
+func (p *PorWriter) writeString(s string) {
+	p.writeInt(len(s))
+	p.write(s)
+}
+
+// Format a number in base 30 the way portable files store them
+func porNumber(f float64) string {
+	if f == sysmis || math.IsNaN(f) || math.IsInf(f, 0) {
+		return "*."
+	}
+
+	var b strings.Builder
+	if f < 0 {
+		b.WriteByte('-')
+		f = -f
+	}
+
+	if f == math.Trunc(f) && f < 1<<53 {
+		b.WriteString(strings.ToUpper(strconv.FormatUint(uint64(f), 30)))
+		b.WriteByte('/')
+		return b.String()
+	}
+
+	// Scale to an integer mantissa with a base 30 exponent
+	exp := int(math.Floor(math.Log(f)/math.Log(30))) - porPrecision + 1
+	power := new(big.Int).Exp(big.NewInt(30), big.NewInt(int64(math.Abs(float64(exp)))), nil)
+	scale := new(big.Float).SetPrec(256).SetInt(power)
+	mantissa := new(big.Float).SetPrec(256).SetFloat64(f)
+	if exp < 0 {
+		mantissa.Mul(mantissa, scale)
+	} else {
+		mantissa.Quo(mantissa, scale)
+	}
+	mantissa.Add(mantissa, big.NewFloat(0.5))
+	m, _ := mantissa.Int(nil)
+
+	thirty := big.NewInt(30)
+	q, r := new(big.Int), new(big.Int)
+	for m.Sign() > 0 {
+		q.QuoRem(m, thirty, r)
+		if r.Sign() != 0 {
+			break
+		}
+		m.Set(q)
+		exp++
+	}
+
+	b.WriteString(strings.ToUpper(m.Text(30)))
+	if exp > 0 {
+		b.WriteString("+" + strings.ToUpper(strconv.FormatInt(int64(exp), 30)))
+	} else if exp < 0 {
+		b.WriteString("-" + strings.ToUpper(strconv.FormatInt(int64(-exp), 30)))
+	}
+	b.WriteByte('/')
+	return b.String()
+}
+
+func (p *PorWriter) writeDictionary() {
+	p.headerRecord()
+	p.variableRecords()
+	p.valueLabelRecords()
+	p.documentRecord()
+	p.write("F") // data
+	p.started = true
+}
+
+func (p *PorWriter) headerRecord() {
+	c := p.now()
+	for i := 0; i < 5; i++ {
+		p.write(string(stob("ASCII SPSS PORT FILE", 40))) // vanity splash
+	}
+	p.write(porCharset)                 // translation table
+	p.write("SPSSPORT")                 // signature
+	p.write("A")                        // version
+	p.writeString(c.Format("20060102")) // creation_date
+	p.writeString(c.Format("150405"))   // creation_time
+	p.write("1")                        // product
+	p.writeString("go-spss " + Version)
+	p.write("4") // variable count
+	p.writeInt(len(p.variables))
+	p.write("5") // precision
+	p.writeInt(porPrecision)
+}
+
+func (p *PorWriter) variableRecords() {
+	for _, v := range p.variables {
+		p.write("7") // variable
+		if v.spssType == SpssTypeString {
+			p.writeInt(int(v.width))
+		} else {
+			p.writeInt(0)
+		}
+		p.writeString(v.shortName)
+
+		width := int(v.width)
+		if v.format == SpssFormatAHex {
+			width *= 2
+		}
+		for i := 0; i < 2; i++ { // print and write format
+			p.writeInt(int(v.format))
+			p.writeInt(width)
+			p.writeInt(int(v.decimal))
+		}
+
+		missing := v.missing
+		if v.missingCode < 0 {
+			low, high := missing[0], missing[1]
+			switch {
+			case low <= lowest:
+				p.write("9") // LO THRU high
+				p.writeNumber(high)
+			case high >= highest:
+				p.write("A") // low THRU HI
+				p.writeNumber(low)
+			default:
+				p.write("B") // low THRU high
+				p.writeNumber(low)
+				p.writeNumber(high)
+			}
+			missing = missing[2:]
+		}
+		for _, m := range missing {
+			p.write("8") // missing value
+			p.writeNumber(m)
+		}
+		for _, m := range v.missingStrings {
+			p.write("8") // missing value
+			p.writeString(m)
+		}
+
+		if len(v.label) > 0 {
+			p.write("C") // variable label
+			p.writeString(trim(v.label, 255))
+		}
+	}
+}
+
+func (p *PorWriter) valueLabelRecords() {
+	for _, v := range p.variables {
+		if len(v.labels) == 0 {
+			continue
+		}
+
+		p.write("D") // value labels
+		p.writeInt(1)
+		p.writeString(v.shortName)
+		p.writeInt(len(v.labels))
+
+		for i, label := range v.labels {
+			if v.spssType == SpssTypeString {
+				p.writeString(label.Value)
+			} else {
+				p.writeNumber(v.labelValues[i])
+			}
+			p.writeString(trim(label.Desc, 255))
+		}
+	}
+}
+
+func (p *PorWriter) documentRecord() {
+	if len(p.documents) == 0 {
+		return
+	}
+
+	p.write("E") // documents
+	p.writeInt(len(p.documents))
+	for _, line := range p.documents {
+		p.writeString(line)
+	}
+}
@@ -0,0 +1,145 @@
+package gospss
+
+import (
+	"bytes"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPorNumber(t *testing.T) {
+	tests := []struct {
+		number float64
+		want   string
+	}{
+		// Integers and system-missing as written by PSPP
+		{0, "0/"},
+		{1, "1/"},
+		{11, "B/"},
+		{29, "T/"},
+		{30, "10/"},
+		{100, "3A/"},
+		{-1, "-1/"},
+		{-100, "-3A/"},
+		{1234567, "1FLM7/"},
+		{sysmis, "*."},
+		{math.NaN(), "*."},
+		{math.Inf(1), "*."},
+
+		// Fractions are a base 30 mantissa followed by a base 30 exponent
+		{0.5, "F-1/"},
+		{1.5, "1F-1/"},
+		{-0.5, "-F-1/"},
+		{0.1, "3-1/"},
+		{1.0 / 900, "1-2/"},
+		{12.25, "C7F-2/"},
+	}
+
+	for _, tt := range tests {
+		if got := porNumber(tt.number); got != tt.want {
+			t.Errorf("porNumber(%v) = %q, want %q", tt.number, got, tt.want)
+		}
+	}
+}
+
+func writeTestPor(t *testing.T, now time.Time) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	porWriter, err := NewPorWriter(&buf, WithPorClock(func() time.Time { return now }))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, V := range []Variable{
+		{Name: "AGE", Type: SpssTypeNumeric, Label: "Age", Labels: []Label{{Value: "1", Desc: "One"}}},
+		{Name: "NAME", Type: SpssTypeString, Width: 10},
+	} {
+		V := V
+		if err := porWriter.AddVariable(&V); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := porWriter.SetDocuments([]string{"First line", "Second line"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := porWriter.AddValueRow(map[string]string{"AGE": "42", "NAME": "John"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := porWriter.Finish(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func TestPorWriterLines(t *testing.T) {
+	data := writeTestPor(t, time.Date(2021, 3, 14, 15, 9, 26, 0, time.UTC))
+
+	if !bytes.HasSuffix(data, []byte("\r\n")) {
+		t.Fatal("Output does not end with a line break")
+	}
+
+	lines := strings.Split(strings.TrimSuffix(string(data), "\r\n"), "\r\n")
+	for i, line := range lines {
+		if len(line) != 80 {
+			t.Errorf("Line %d has %d characters, want 80", i+1, len(line))
+		}
+	}
+
+	last := lines[len(lines)-1]
+	if z := strings.TrimRight(last, "Z"); len(last)-len(z) < 1 {
+		t.Errorf("Last line %q does not end with Z", last)
+	}
+}
+
+func TestPorWriterClock(t *testing.T) {
+	now := time.Date(2021, 3, 14, 15, 9, 26, 0, time.UTC)
+
+	first := writeTestPor(t, now)
+	second := writeTestPor(t, now)
+	if !bytes.Equal(first, second) {
+		t.Error("Output differs with the same clock")
+	}
+
+	// The date and time are strings preceded by their length
+	flat := strings.Replace(string(first), "\r\n", "", -1)
+	if !strings.Contains(flat, "8/20210314") || !strings.Contains(flat, "6/150926") {
+		t.Error("Output does not contain the creation date and time of the clock")
+	}
+
+	if _, err := NewPorWriter(&bytes.Buffer{}, WithPorClock(nil)); err == nil {
+		t.Error("NewPorWriter() accepted a nil clock")
+	}
+}
+
+func TestPorWriterErrors(t *testing.T) {
+	porWriter, err := NewPorWriter(&bytes.Buffer{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := porWriter.AddVariable(&Variable{Name: "LONG", Type: SpssTypeString, Width: 256}); err == nil {
+		t.Error("AddVariable() accepted a string of 256 bytes")
+	}
+	if err := porWriter.AddVariable(&Variable{Name: "AGE", Type: SpssTypeNumeric}); err != nil {
+		t.Fatal(err)
+	}
+	if err := porWriter.AddVariable(&Variable{Name: "AGE", Type: SpssTypeNumeric}); err == nil {
+		t.Error("AddVariable() accepted a duplicate name")
+	}
+	if err := porWriter.AddValueRow(map[string]string{"AGE": "1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := porWriter.AddVariable(&Variable{Name: "LATE", Type: SpssTypeNumeric}); err == nil {
+		t.Error("AddVariable() succeeded after values are written")
+	}
+	if err := porWriter.AddDocument("Late"); err == nil {
+		t.Error("AddDocument() succeeded after values are written")
+	}
+	if err := porWriter.SetDocuments([]string{"Late"}); err == nil {
+		t.Error("SetDocuments() succeeded after values are written")
+	}
+}
@@ -0,0 +1,553 @@
+package gospss
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math/big"
+	"strings"
+)
+
+// PorReader defines the struct to read SPSS portable files
+type PorReader struct {
+	reader    *bufio.Reader  // Buffered reader
+	table     []int          // Portable code of each byte in the file, -1 if not in the translation table
+	column    int            // Column in the current line
+	pad       int            // Spaces still to return for a line shorter than 80 characters
+	pending   int            // Character put back by unread, -1 if none
+	err       error          // First read error
+	product   string         // Product that wrote the file
+	variables []Variable     // Variables in dictionary order
+	lookup    map[string]int // Position of each variable in variables
+	documents []string       // Document lines
+	decoder   *CaseDecoder   // Decoder of the cases
+}
+
+// porCaseReader reads the elements of the cases in the data record
+type porCaseReader struct {
+	r *PorReader
+}
+
+// NewPorReader - Returns a portable file reader given a file or any other io.Reader, the dictionary
+// is read immediately and the cases can be read with ReadRow or Cases
+func NewPorReader(r io.Reader) (*PorReader, error) {
+	porReader := &PorReader{
+		reader:  bufio.NewReader(r),
+		pending: -1,
+		lookup:  make(map[string]int),
+	}
+
+	if err := porReader.headerRecord(); err != nil {
+		return nil, err
+	}
+
+	if err := porReader.dictionary(); err != nil {
+		return nil, err
+	}
+
+	layout := make([]variable, len(porReader.variables))
+	for i := range porReader.variables {
+		layout[i] = porReader.variables[i].layout()
+	}
+
+	porReader.decoder = newCaseDecoder(layout, &porCaseReader{r: porReader}, sysmis, -1)
+
+	return porReader, nil
+}
+
+// Variables - Returns the variables of the file in dictionary order
+func (r *PorReader) Variables() []Variable {
+	return r.variables
+}
+
+// Cases - Returns the decoder of the cases, use either this or ReadRow
+func (r *PorReader) Cases() *CaseDecoder {
+	return r.decoder
+}
+
+// Documents - Returns the lines of the document record
+func (r *PorReader) Documents() []string {
+	return r.documents
+}
+
+// ProductName - Returns the product that wrote the file
+func (r *PorReader) ProductName() string {
+	return r.product
+}
+
+// ReadRow - Read the next case with the values formatted the way AddValueRow accepts them,
+// system-missing values are left out. Returns io.EOF after the last case.
+func (r *PorReader) ReadRow() (map[string]string, error) {
+	if !r.decoder.Next() {
+		if err := r.decoder.Err(); err != nil {
+			return nil, err
+		}
+		return nil, io.EOF
+	}
+
+	return r.decoder.Row().Values(), nil
+}
+
+// Keep the first error that occurred
+func (r *PorReader) setErr(err error) {
+	if r.err == nil && err != nil {
+		r.err = unexpectedEOF(err)
+	}
+}
+
+// Read a byte of the file, the line breaks are left out and lines shorter than 80 characters
+// are filled up with spaces
+func (r *PorReader) readByte() (byte, error) {
+	for {
+		if r.pad > 0 {
+			r.pad--
+			return ' ', nil
+		}
+
+		b, err := r.reader.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+
+		switch b {
+		case '\r':
+			continue
+		case '\n':
+			if r.column < 80 {
+				r.pad = 80 - r.column
+			}
+			r.column = 0
+			continue
+		}
+
+		r.column++
+		return b, nil
+	}
+}
+
+// Returns the next character translated to the local character set
+func (r *PorReader) next() byte {
+	if r.pending >= 0 {
+		c := byte(r.pending)
+		r.pending = -1
+		return c
+	}
+
+	if r.err != nil {
+		return 0
+	}
+
+	b, err := r.readByte()
+	if err != nil {
+		r.setErr(err)
+		return 0
+	}
+
+	// Until the translation table is read bytes are returned as is
+	if r.table == nil {
+		return b
+	}
+
+	// Unused positions of the character set are filled with zeros
+	code := r.table[b]
+	if code < 0 || (porCharset[code] == '0' && code != 64) {
+		return b
+	}
+	return porCharset[code]
+}
+
+// Put a character back, the next call of next returns it again
+func (r *PorReader) unread(c byte) {
+	r.pending = int(c)
+}
+
+// Returns the next character that is not a space
+func (r *PorReader) skipSpaces() byte {
+	c := r.next()
+	for c == ' ' && r.err == nil {
+		c = r.next()
+	}
+	return c
+}
+
+// Value of a base 30 digit, -1 if the character is not a digit
+func porDigit(c byte) int {
+	switch {
+	case c >= '0' && c <= '9':
+		return int(c - '0')
+	case c >= 'A' && c <= 'T':
+		return int(c-'A') + 10
+	default:
+		return -1
+	}
+}
+
+// Read a base 30 number, *. is system-missing
+func (r *PorReader) readNumber() float64 {
+	c := r.skipSpaces()
+	if c == '*' {
+		r.next() // the dot after the asterisk
+		return sysmis
+	}
+
+	negative := c == '-'
+	if negative {
+		c = r.next()
+	}
+
+	thirty := big.NewInt(30)
+	mantissa := new(big.Int)
+	digits, exp := 0, 0
+	dot := false
+	for {
+		if c == '.' && !dot {
+			dot = true
+			c = r.next()
+			continue
+		}
+		d := porDigit(c)
+		if d < 0 {
+			break
+		}
+		mantissa.Mul(mantissa, thirty).Add(mantissa, big.NewInt(int64(d)))
+		digits++
+		if dot {
+			exp--
+		}
+		c = r.next()
+	}
+
+	if c == '+' || c == '-' {
+		sign := c
+		e, d := 0, porDigit(r.next())
+		if d < 0 {
+			r.setErr(fmt.Errorf("Invalid exponent in number"))
+			return 0
+		}
+		for ; d >= 0; d = porDigit(c) {
+			e = e*30 + d
+			if e > 1000 {
+				r.setErr(fmt.Errorf("Exponent of number out of range"))
+				return 0
+			}
+			c = r.next()
+		}
+		if sign == '-' {
+			e = -e
+		}
+		exp += e
+	}
+
+	if r.err != nil {
+		return 0
+	}
+	if c != '/' || digits == 0 {
+		r.setErr(fmt.Errorf("Invalid number, unexpected character %q", c))
+		return 0
+	}
+
+	f := new(big.Float).SetPrec(256).SetInt(mantissa)
+	if exp != 0 {
+		e := exp
+		if e < 0 {
+			e = -e
+		}
+		power := new(big.Float).SetPrec(256).SetInt(new(big.Int).Exp(thirty, big.NewInt(int64(e)), nil))
+		if exp < 0 {
+			f.Quo(f, power)
+		} else {
+			f.Mul(f, power)
+		}
+	}
+
+	value, _ := f.Float64()
+	if negative {
+		value = -value
+	}
+	return value
+}
+
+// Read a number that must be an integer
+func (r *PorReader) readInt() int {
+	f := r.readNumber()
+	if r.err == nil && (f != float64(int(f)) || f < -1<<31 || f > 1<<31-1) {
+		r.setErr(fmt.Errorf("Expected an integer but found %v", f))
+		return 0
+	}
+	return int(f)
+}
+
+// Read a string preceded by its length
+func (r *PorReader) readString() string {
+	n := r.readInt()
+	if r.err != nil {
+		return ""
+	}
+	if n < 0 || n > 32767 {
+		r.setErr(fmt.Errorf("Invalid string length %d", n))
+		return ""
+	}
+
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = r.next()
+	}
+	return string(b)
+}
+
+func (r *PorReader) headerRecord() error {
+	// Skip the vanity splash
+	for i := 0; i < 200; i++ {
+		r.next()
+	}
+
+	var table [256]byte
+	for i := range table {
+		table[i] = r.next()
+	}
+	if r.err != nil {
+		return fmt.Errorf("Cannot read header: %v", r.err)
+	}
+
+	// The first occurrence of a character decides its portable code, the first 64
+	// positions are control characters that are often filled with zeros
+	r.table = make([]int, 256)
+	for i := range r.table {
+		r.table[i] = -1
+	}
+	for i := 64; i < 256; i++ {
+		if r.table[table[i]] < 0 {
+			r.table[table[i]] = i
+		}
+	}
+	for i := 0; i < 64; i++ {
+		if r.table[table[i]] < 0 {
+			r.table[table[i]] = i
+		}
+	}
+
+	signature := make([]byte, 8)
+	for i := range signature {
+		signature[i] = r.next()
+	}
+	if r.err != nil {
+		return fmt.Errorf("Cannot read header: %v", r.err)
+	}
+	if string(signature) != "SPSSPORT" {
+		return fmt.Errorf("Not a portable file, signature is %q", signature)
+	}
+
+	if version := r.next(); version != 'A' {
+		return fmt.Errorf("Unsupported portable file version %q", version)
+	}
+
+	r.readString() // creation_date
+	r.readString() // creation_time
+
+	if r.err != nil {
+		return fmt.Errorf("Cannot read header: %v", r.err)
+	}
+	return nil
+}
+
+func (r *PorReader) dictionary() error {
+	var current *Variable
+
+	for {
+		tag := r.next()
+		if r.err != nil {
+			break
+		}
+
+		switch tag {
+		case '1': // product
+			r.product = r.readString()
+		case '2', '3': // author and subproduct
+			r.readString()
+		case '4': // variable count
+			r.readInt()
+		case '5': // precision
+			r.readInt()
+		case '6': // weight variable
+			r.readString()
+		case '7':
+			current = r.variableRecord()
+		case '8', '9', 'A', 'B', 'C':
+			if current == nil {
+				return fmt.Errorf("Found record %c before the first variable", tag)
+			}
+			r.variableSubRecord(tag, current)
+		case 'D':
+			r.valueLabelRecord()
+		case 'E':
+			r.documentRecord()
+		case 'F': // data
+			return nil
+		default:
+			return fmt.Errorf("Unexpected record %q in the dictionary", tag)
+		}
+
+		if r.err != nil {
+			break
+		}
+	}
+
+	return fmt.Errorf("Cannot read dictionary: %v", r.err)
+}
+
+func (r *PorReader) variableRecord() *Variable {
+	width := r.readInt()
+	name := r.readString()
+
+	var format [6]int // print and write format
+	for i := range format {
+		format[i] = r.readInt()
+	}
+	if r.err != nil {
+		return nil
+	}
+
+	V := Variable{Name: name}
+	if width == 0 {
+		V.setPrint(SpssFormat(format[0]), int16(format[1]), int8(format[2]))
+	} else {
+		V.Type = SpssTypeString
+		V.Format = SpssFormatA
+		if SpssFormat(format[0]) == SpssFormatAHex {
+			V.Format = SpssFormatAHex
+		}
+		V.Width = int16(width)
+	}
+
+	if _, exists := r.lookup[name]; exists {
+		r.setErr(fmt.Errorf("Duplicate variable %s", name))
+		return nil
+	}
+
+	r.lookup[name] = len(r.variables)
+	r.variables = append(r.variables, V)
+	return &r.variables[len(r.variables)-1]
+}
+
+// Read a missing value or the label of a variable
+func (r *PorReader) variableSubRecord(tag byte, V *Variable) {
+	switch tag {
+	case '8': // missing value
+		if V.Type == SpssTypeString {
+			V.MissingValues.Values = append(V.MissingValues.Values, strings.TrimRight(r.readString(), " "))
+		} else {
+			V.MissingValues.Values = append(V.MissingValues.Values, formatNumber(V.getPrint(), r.readNumber()))
+		}
+	case '9': // LO THRU high
+		V.MissingValues.Range = &MissingRange{Low: "LO", High: formatMissingBound(V.getPrint(), r.readNumber())}
+	case 'A': // low THRU HI
+		V.MissingValues.Range = &MissingRange{Low: formatMissingBound(V.getPrint(), r.readNumber()), High: "HI"}
+	case 'B': // low THRU high
+		low := r.readNumber()
+		high := r.readNumber()
+		V.MissingValues.Range = &MissingRange{Low: formatMissingBound(V.getPrint(), low), High: formatMissingBound(V.getPrint(), high)}
+	case 'C': // variable label
+		V.Label = r.readString()
+	}
+}
+
+func (r *PorReader) valueLabelRecord() {
+	count := r.readInt()
+	if count < 0 && r.err == nil {
+		r.setErr(fmt.Errorf("Invalid variable count %d of value labels", count))
+	}
+
+	var indexes []int
+	for i := 0; i < count && r.err == nil; i++ {
+		name := r.readString()
+		index, found := r.lookup[name]
+		if !found && r.err == nil {
+			r.setErr(fmt.Errorf("Value labels for unknown variable %s", name))
+		}
+		indexes = append(indexes, index)
+	}
+	if r.err != nil || len(indexes) == 0 {
+		return
+	}
+
+	// All variables of the record have the type of the first
+	first := &r.variables[indexes[0]]
+
+	labels := r.readInt()
+	for i := 0; i < labels && r.err == nil; i++ {
+		var label Label
+		if first.Type == SpssTypeString {
+			label.Value = strings.TrimRight(r.readString(), " ")
+		} else {
+			label.Value = formatNumber(first.getPrint(), r.readNumber())
+		}
+		label.Desc = r.readString()
+
+		for _, index := range indexes {
+			r.variables[index].Labels = append(r.variables[index].Labels, label)
+		}
+	}
+}
+
+func (r *PorReader) documentRecord() {
+	count := r.readInt()
+	for i := 0; i < count && r.err == nil; i++ {
+		r.documents = append(r.documents, strings.TrimRight(r.readString(), " "))
+	}
+}
+
+// Returns true at the Z that ends the data or at the end of the file
+func (c *porCaseReader) end() (bool, error) {
+	r := c.r
+	if r.err != nil {
+		return false, r.err
+	}
+
+	ch := r.skipSpaces()
+	if r.err == io.ErrUnexpectedEOF {
+		return true, nil
+	}
+	if r.err != nil {
+		return false, r.err
+	}
+	if ch == 'Z' {
+		return true, nil
+	}
+
+	r.unread(ch)
+	return false, nil
+}
+
+func (c *porCaseReader) ReadNumber() (float64, error) {
+	if end, err := c.end(); end || err != nil {
+		if end {
+			return 0, io.EOF
+		}
+		return 0, err
+	}
+
+	f := c.r.readNumber()
+	return f, c.r.err
+}
+
+func (c *porCaseReader) ReadString(elements int) (string, error) {
+	if end, err := c.end(); end || err != nil {
+		if end {
+			return "", io.EOF
+		}
+		return "", err
+	}
+
+	s := c.r.readString()
+	if c.r.err != nil {
+		return "", c.r.err
+	}
+
+	// Pad to the storage of the variable like the other case readers
+	if size := elements * 8; len(s) < size {
+		s += strings.Repeat(" ", size-len(s))
+	} else {
+		s = s[:size]
+	}
+	return s, nil
+}
@@ -0,0 +1,217 @@
+package gospss
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Returns a reader of the given characters, without a translation table
+func newTestPorReader(s string) *PorReader {
+	return &PorReader{reader: bufio.NewReader(strings.NewReader(s)), pending: -1}
+}
+
+func TestPorReadNumber(t *testing.T) {
+	tests := []struct {
+		input string
+		want  float64
+	}{
+		{"0/", 0},
+		{"1/", 1},
+		{"3A/", 100},
+		{"-1/", -1},
+		{"  B/", 11},
+		{"*.", sysmis},
+		{"1+2/", 900},
+		{"F-1/", 0.5},
+		{"1F-1/", 1.5},
+		{".F/", 0.5},
+		{"1.F/", 1.5},
+		{"-.F/", -0.5},
+		{"C.7F/", 12.25},
+		{"1.F+1/", 45},
+	}
+
+	for _, tt := range tests {
+		r := newTestPorReader(tt.input)
+		got := r.readNumber()
+		if r.err != nil {
+			t.Errorf("readNumber(%q) failed: %v", tt.input, r.err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("readNumber(%q) = %v, want %v", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestPorReadNumberInvalid(t *testing.T) {
+	for _, input := range []string{"/", "X/", "1", "1+/", "1+ZZZZ/", "-/"} {
+		r := newTestPorReader(input)
+		r.readNumber()
+		if r.err == nil {
+			t.Errorf("readNumber(%q) succeeded, want an error", input)
+		}
+	}
+}
+
+func TestPorNumberRoundTrip(t *testing.T) {
+	for _, f := range []float64{0.1, 1.0 / 3, 123456.789, -1e-10, 1e15 + 0.5, 1e100, -2.5e-100, 1 << 60, math.MaxFloat64} {
+		r := newTestPorReader(porNumber(f))
+		got := r.readNumber()
+		if r.err != nil {
+			t.Errorf("Reading %v as %q failed: %v", f, porNumber(f), r.err)
+			continue
+		}
+		if math.Abs(got-f) > math.Abs(f)*1e-15 {
+			t.Errorf("Reading %v as %q returned %v", f, porNumber(f), got)
+		}
+	}
+}
+
+func TestPorReaderRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	porWriter, err := NewPorWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	variables := []Variable{
+		{
+			Name:          "AGE",
+			Type:          SpssTypeNumeric,
+			Width:         8,
+			Label:         "Age",
+			Labels:        []Label{{Value: "1", Desc: "One"}},
+			MissingValues: MissingValues{Values: []string{"99"}},
+		},
+		{
+			Name:          "INCOME",
+			Type:          SpssTypeNumeric,
+			Width:         10,
+			Decimal:       2,
+			MissingValues: MissingValues{Range: &MissingRange{Low: "LO", High: "-1"}},
+		},
+		{
+			Name:   "NAME",
+			Type:   SpssTypeString,
+			Width:  10,
+			Labels: []Label{{Value: "NA", Desc: "Not answered"}},
+		},
+		{Name: "COMMENTS", Type: SpssTypeString, Width: 255},
+	}
+	for _, V := range variables {
+		V := V
+		if err := porWriter.AddVariable(&V); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := porWriter.SetDocuments([]string{"First line", "Second line"}); err != nil {
+		t.Fatal(err)
+	}
+
+	rows := []map[string]string{
+		{"AGE": "42", "INCOME": "1234.56", "NAME": "John", "COMMENTS": strings.Repeat("x", 255)},
+		{"AGE": "99", "INCOME": "-0.25", "NAME": "NA", "COMMENTS": ""},
+		{"NAME": "", "COMMENTS": "short"},
+	}
+	for _, row := range rows {
+		if err := porWriter.AddValueRow(row); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := porWriter.Finish(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewPorReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := r.Variables(); len(got) != len(variables) {
+		t.Fatalf("Read %d variables, want %d", len(got), len(variables))
+	}
+	for i, got := range r.Variables() {
+		want := variables[i]
+		if got.Name != want.Name || got.Type != want.Type || got.Width != want.Width || got.Decimal != want.Decimal ||
+			got.Label != want.Label {
+			t.Errorf("Variable %d = %+v, want %+v", i, got, want)
+		}
+		if len(want.Labels) > 0 && !reflect.DeepEqual(got.Labels, want.Labels) {
+			t.Errorf("Variable %s has labels %v, want %v", got.Name, got.Labels, want.Labels)
+		}
+		if len(want.MissingValues.Values) > 0 && !reflect.DeepEqual(got.MissingValues.Values, want.MissingValues.Values) ||
+			!reflect.DeepEqual(got.MissingValues.Range, want.MissingValues.Range) {
+			t.Errorf("Variable %s has missing values %+v, want %+v", got.Name, got.MissingValues, want.MissingValues)
+		}
+	}
+
+	if want := []string{"First line", "Second line"}; !reflect.DeepEqual(r.Documents(), want) {
+		t.Errorf("Documents() = %q, want %q", r.Documents(), want)
+	}
+	if !strings.HasPrefix(r.ProductName(), "go-spss") {
+		t.Errorf("ProductName() = %q", r.ProductName())
+	}
+
+	for i, want := range rows {
+		got, err := r.ReadRow()
+		if err != nil {
+			t.Fatalf("Row %d: %v", i+1, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Row %d = %q, want %q", i+1, got, want)
+		}
+	}
+	if _, err := r.ReadRow(); err != io.EOF {
+		t.Errorf("ReadRow() after the last row returned %v, want io.EOF", err)
+	}
+}
+
+func TestPorReaderMalformed(t *testing.T) {
+	valid := string(writeTestPor(t, time.Date(2021, 3, 14, 15, 9, 26, 0, time.UTC)))
+
+	tests := []struct {
+		name string
+		data string
+		err  string
+	}{
+		{"empty", "", "Cannot read header"},
+		{"not portable", strings.Replace(valid, "SPSSPORT", "SPSSXXXX", 1), "Not a portable file"},
+		{"version", strings.Replace(valid, "SPSSPORTA", "SPSSPORTZ", 1), "Unsupported portable file version"},
+		{"truncated", valid[:600], "Cannot read dictionary"},
+		{"negative label count", strings.Replace(valid, "D1/3/AG", "D-1/3/A", 1), "Invalid variable count -1"},
+		{"unknown label variable", strings.Replace(valid, "D1/3/AG", "D1/3/XX", 1), "Value labels for unknown variable"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewPorReader(strings.NewReader(tt.data))
+			if err == nil {
+				t.Fatalf("NewPorReader() succeeded, want error containing %q", tt.err)
+			}
+			if !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("NewPorReader() error = %q, want it to contain %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestPorReaderTruncatedCases(t *testing.T) {
+	valid := writeTestPor(t, time.Date(2021, 3, 14, 15, 9, 26, 0, time.UTC))
+
+	// Cut the file in the middle of the first case
+	end := bytes.Index(valid, []byte("F1C/4/Jo")) + 6
+	r, err := NewPorReader(bytes.NewReader(valid[:end]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.ReadRow(); err == nil || err == io.EOF {
+		t.Errorf("ReadRow() of a truncated case returned %v, want an error", err)
+	}
+}
@@ -0,0 +1,770 @@
+package gospss
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// caseReader reads the elements of the cases in the file's compression
+type caseReader interface {
+	ReadNumber() (float64, error)
+	ReadString(elements int) (string, error)
+}
+
+// Variable record as found in the file, very long strings consist of several
+type variableRecord struct {
+	index       int32               // Dictionary index
+	shortName   string              // Name in the variable record
+	name        string              // Long name, if any
+	width       int32               // 0 for numeric, the string width otherwise
+	print       int32               // Print format
+	label       string              // Variable label
+	missingCode int32               // n_missing_values
+	missing     [][]byte            // Raw missing values
+	measure     int32               // Measure from the display parameters
+	longWidth   int32               // Width of a very long string
+	attributes  map[string][]string // Custom attributes including the role
+	labels      []rawLabel
+}
+
+// Value label with the value as found in the file
+type rawLabel struct {
+	value []byte
+	desc  string
+}
+
+// SpssReader defines the struct to read SPSS files
+type SpssReader struct {
+	reader      *bufio.Reader         // Buffered reader
+	source      io.Reader             // Original reader
+	counter     *countReader          // Counts the bytes read from the original reader
+	order       binary.ByteOrder      // Byte order of the file
+	err         error                 // First read error
+	product     string                // Product that wrote the file
+	fileLabel   string                // File label
+	compression SpssCompression       // Compression of the cases
+	bias        float64               // Bias of the bytecode compression
+	ncases      int64                 // Number of cases, -1 if unknown
+	sysmis      float64               // System-missing value
+	encoding    string                // Character encoding
+	records     []*variableRecord     // Variable records in dictionary order
+	indexes     map[int32]int         // Position in records of each dictionary index
+	elements    int32                 // Number of dictionary indexes, including continuations
+	documents   []string              // Document lines
+	attributes  map[string][]string   // Custom attributes of the file
+	mrsets      []MultipleResponseSet // Multiple response sets
+	variables   []Variable            // Reassembled variables
+	layout      []variable            // Storage of the variables in a case
+	decoder     *CaseDecoder          // Decoder of the cases
+}
+
+// NewSpssReader - Returns an SPSS Reader struct given a file, the dictionary is read immediately
+// and the cases can be read with ReadRow or Cases
+func NewSpssReader(r io.Reader) (*SpssReader, error) {
+	counter := &countReader{Reader: r}
+
+	spssReader := &SpssReader{
+		reader:  bufio.NewReader(counter),
+		source:  r,
+		counter: counter,
+		order:   endian,
+		sysmis:  sysmis,
+		indexes: make(map[int32]int),
+	}
+
+	if err := spssReader.headerRecord(); err != nil {
+		return nil, err
+	}
+
+	if err := spssReader.dictionary(); err != nil {
+		return nil, err
+	}
+
+	var cases caseReader
+	switch spssReader.compression {
+	case SpssCompressionNone:
+		cases = newUncompressedReader(spssReader.reader, spssReader.order)
+	case SpssCompressionBytecode:
+		bytecode := newBytecodeReader(spssReader.reader, spssReader.order, spssReader.bias)
+		bytecode.sysmis = spssReader.sysmis
+		cases = bytecode
+	case SpssCompressionZlib:
+		blocks, err := spssReader.zlibBlocks()
+		if err != nil {
+			return nil, err
+		}
+		bytecode := newBytecodeReader(newZlibReader(spssReader.reader, blocks), spssReader.order, spssReader.bias)
+		bytecode.sysmis = spssReader.sysmis
+		cases = bytecode
+	default:
+		return nil, fmt.Errorf("Unsupported compression %d", spssReader.compression)
+	}
+
+	spssReader.decoder = newCaseDecoder(spssReader.layout, cases, spssReader.sysmis, spssReader.ncases)
+
+	return spssReader, nil
+}
+
+// Variables - Returns the variables of the file in dictionary order
+func (r *SpssReader) Variables() []Variable {
+	return r.variables
+}
+
+// FileAttributes - Returns the custom attributes of the file
+func (r *SpssReader) FileAttributes() map[string][]string {
+	return r.attributes
+}
+
+// MultipleResponseSets - Returns the multiple response sets of the file
+func (r *SpssReader) MultipleResponseSets() []MultipleResponseSet {
+	return r.mrsets
+}
+
+// Cases - Returns the decoder of the cases, use either this or ReadRow
+func (r *SpssReader) Cases() *CaseDecoder {
+	return r.decoder
+}
+
+// Documents - Returns the lines of the document record
+func (r *SpssReader) Documents() []string {
+	return r.documents
+}
+
+// FileLabel - Returns the label of the file
+func (r *SpssReader) FileLabel() string {
+	return r.fileLabel
+}
+
+// ProductName - Returns the product that wrote the file
+func (r *SpssReader) ProductName() string {
+	return r.product
+}
+
+// Compression - Returns the way the cases are stored
+func (r *SpssReader) Compression() SpssCompression {
+	return r.compression
+}
+
+// NumberOfCases - Returns the number of cases in the file or -1 when unknown
+func (r *SpssReader) NumberOfCases() int64 {
+	return r.ncases
+}
+
+// Encoding - Returns the character encoding of the file, empty when not specified
+func (r *SpssReader) Encoding() string {
+	return r.encoding
+}
+
+// countReader keeps track of the number of bytes read
+type countReader struct {
+	io.Reader
+	n int64
+}
+
+func (r *countReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+// Offset in the file of the next byte read
+func (r *SpssReader) offset() int64 {
+	return r.counter.n - int64(r.reader.Buffered())
+}
+
+// Keep the first error that occurred
+func (r *SpssReader) setErr(err error) {
+	if r.err == nil && err != nil {
+		r.err = unexpectedEOF(err)
+	}
+}
+
+func (r *SpssReader) readBytes(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 {
+		r.setErr(fmt.Errorf("Invalid length %d", n))
+		return nil
+	}
+	b, err := readLimited(r.reader, int64(n))
+	r.setErr(err)
+	return b
+}
+
+// Read exactly n bytes, the buffer grows with the data that is read so a corrupt
+// length cannot allocate more than the file holds
+func readLimited(reader io.Reader, n int64) ([]byte, error) {
+	b, err := ioutil.ReadAll(io.LimitReader(reader, n))
+	if err == nil && int64(len(b)) < n {
+		err = io.ErrUnexpectedEOF
+	}
+	return b, err
+}
+
+func (r *SpssReader) readInt32() int32 {
+	var i int32
+	if r.err == nil {
+		r.setErr(binary.Read(r.reader, r.order, &i))
+	}
+	return i
+}
+
+func (r *SpssReader) headerRecord() error {
+	header := make([]byte, 176)
+	if _, err := io.ReadFull(r.reader, header); err != nil {
+		return fmt.Errorf("Cannot read header: %v", unexpectedEOF(err))
+	}
+
+	switch string(header[:4]) {
+	case "$FL2", "$FL3":
+	default:
+		return fmt.Errorf("Not an SPSS file")
+	}
+
+	// The layout code is 2 or 3, otherwise the file has the other byte order
+	if layout := binary.LittleEndian.Uint32(header[64:]); layout != 2 && layout != 3 {
+		r.order = binary.BigEndian
+	}
+
+	r.product = strings.TrimRight(string(header[4:64]), " ")
+	r.compression = SpssCompression(r.order.Uint32(header[72:]))
+	r.ncases = int64(int32(r.order.Uint32(header[headerNCasesOffset:])))
+	r.bias = bytesToFloat64(r.order, header[84:])
+	r.fileLabel = strings.TrimRight(string(header[109:173]), " ")
+
+	return nil
+}
+
+func bytesToFloat64(order binary.ByteOrder, b []byte) float64 {
+	var f float64
+	binary.Read(bytes.NewReader(b[:8]), order, &f)
+	return f
+}
+
+// Read the zheader following the dictionary and the ztrailer it points to, the
+// reader is positioned at the first block afterwards
+func (r *SpssReader) zlibBlocks() ([]zlibBlock, error) {
+	seeker, ok := r.source.(io.ReadSeeker)
+	if !ok {
+		return nil, fmt.Errorf("ZSAV files require an io.ReadSeeker")
+	}
+
+	offset := r.offset()
+	zheader := r.readBytes(24)
+	if r.err != nil {
+		return nil, fmt.Errorf("Cannot read zheader: %v", r.err)
+	}
+
+	zheaderOffset := int64(r.order.Uint64(zheader[0:]))
+	trailerOffset := int64(r.order.Uint64(zheader[8:]))
+	trailerLength := int64(r.order.Uint64(zheader[16:]))
+
+	if zheaderOffset != offset {
+		return nil, fmt.Errorf("Zheader offset %d does not match its position %d", zheaderOffset, offset)
+	}
+	if trailerOffset < offset+24 {
+		return nil, fmt.Errorf("Invalid ztrailer offset %d", trailerOffset)
+	}
+	if trailerLength < 24 || trailerLength%24 != 0 || trailerLength > 24*(1<<24) {
+		return nil, fmt.Errorf("Invalid ztrailer length %d", trailerLength)
+	}
+
+	if _, err := seeker.Seek(trailerOffset, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("Cannot seek to ztrailer: %v", err)
+	}
+	trailer, err := readLimited(seeker, trailerLength)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot read ztrailer: %v", unexpectedEOF(err))
+	}
+
+	blocks, err := parseZtrailer(r.order, trailer, zheaderOffset, trailerOffset, r.bias)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := seeker.Seek(offset+24, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("Cannot seek to the first ZSAV block: %v", err)
+	}
+	r.reader.Reset(seeker)
+
+	return blocks, nil
+}
+
+// Read all records up to and including the termination record
+func (r *SpssReader) dictionary() error {
+	for {
+		recType := r.readInt32()
+		if r.err != nil {
+			return fmt.Errorf("Cannot read dictionary: %v", r.err)
+		}
+
+		switch recType {
+		case 2:
+			r.variableRecord()
+		case 3:
+			r.valueLabelRecord()
+		case 6:
+			r.documentRecord()
+		case 7:
+			r.extensionRecord()
+		case 999:
+			r.readInt32() // filler
+			if r.err != nil {
+				return fmt.Errorf("Cannot read dictionary: %v", r.err)
+			}
+			return r.buildVariables()
+		default:
+			return fmt.Errorf("Unknown record type %d", recType)
+		}
+
+		if r.err != nil {
+			return fmt.Errorf("Cannot read record type %d: %v", recType, r.err)
+		}
+	}
+}
+
+func (r *SpssReader) variableRecord() {
+	width := r.readInt32()
+	hasLabel := r.readInt32()
+	missingCode := r.readInt32()
+	print := r.readInt32()
+	r.readInt32() // write
+	name := strings.TrimRight(string(r.readBytes(8)), " ")
+
+	r.elements++
+	rec := &variableRecord{
+		index:       r.elements,
+		shortName:   name,
+		width:       width,
+		print:       print,
+		missingCode: missingCode,
+	}
+
+	if hasLabel == 1 {
+		l := r.readInt32()
+		rec.label = string(r.readBytes(int(l)))
+		r.readBytes(int((4 - l%4) % 4)) // padding
+	}
+
+	n := missingCode
+	if n < 0 {
+		n = -n
+	}
+	for i := int32(0); i < n && r.err == nil; i++ {
+		rec.missing = append(rec.missing, r.readBytes(8))
+	}
+
+	// Continuation records only take up a dictionary index
+	if width >= 0 {
+		r.indexes[rec.index] = len(r.records)
+		r.records = append(r.records, rec)
+	}
+}
+
+func (r *SpssReader) valueLabelRecord() {
+	count := r.readInt32()
+	if count < 0 {
+		r.setErr(fmt.Errorf("Invalid label count %d", count))
+		return
+	}
+
+	var labels []rawLabel
+	for i := int32(0); i < count && r.err == nil; i++ {
+		value := r.readBytes(8)
+		l := r.readBytes(1)
+		if r.err != nil {
+			break
+		}
+		desc := r.readBytes(int(l[0]))
+		r.readBytes((8 - (int(l[0])+1)%8) % 8) // padding
+		labels = append(labels, rawLabel{value: value, desc: string(desc)})
+	}
+
+	// The variables the labels belong to follow in a type 4 record
+	if recType := r.readInt32(); recType != 4 && r.err == nil {
+		r.setErr(fmt.Errorf("Expected record type 4 instead of %d", recType))
+	}
+	varCount := r.readInt32()
+	for i := int32(0); i < varCount && r.err == nil; i++ {
+		index := r.readInt32()
+		pos, ok := r.indexes[index]
+		if !ok {
+			r.setErr(fmt.Errorf("Invalid variable index %d for value labels", index))
+			break
+		}
+		r.records[pos].labels = append(r.records[pos].labels, labels...)
+	}
+}
+
+func (r *SpssReader) documentRecord() {
+	n := r.readInt32()
+	for i := int32(0); i < n && r.err == nil; i++ {
+		r.documents = append(r.documents, strings.TrimRight(string(r.readBytes(80)), " "))
+	}
+}
+
+func (r *SpssReader) extensionRecord() {
+	subtype := r.readInt32()
+	size := r.readInt32()
+	count := r.readInt32()
+	if r.err != nil {
+		return
+	}
+	if size < 0 || count < 0 || int64(size)*int64(count) > 1<<31 {
+		r.setErr(fmt.Errorf("Invalid size %d and count %d of extension record %d", size, count, subtype))
+		return
+	}
+
+	data := r.readBytes(int(int64(size) * int64(count)))
+	if r.err != nil {
+		return
+	}
+
+	switch subtype {
+	case 4:
+		if len(data) >= 8 {
+			r.sysmis = bytesToFloat64(r.order, data)
+		}
+	case 7, 19:
+		sets, err := parseMRSets(string(data))
+		if err != nil {
+			r.setErr(err)
+			return
+		}
+		r.mrsets = append(r.mrsets, sets...)
+	case 11:
+		r.displayParameters(data, int(count))
+	case 13:
+		r.longVariableNames(data)
+	case 14:
+		r.veryLongStrings(data)
+	case 16:
+		if len(data) >= 16 && r.ncases < 0 {
+			r.ncases = int64(r.order.Uint64(data[8:]))
+		}
+	case 17:
+		attributes, _, err := parseAttributes(string(data), 0)
+		if err != nil {
+			r.setErr(fmt.Errorf("Invalid file attributes: %v", err))
+			return
+		}
+		r.attributes = attributes
+	case 18:
+		r.variableAttributes(string(data))
+	case 20:
+		r.encoding = string(data)
+	case 21:
+		r.longStringValueLabels(data)
+	case 22:
+		r.longStringMissingValues(data)
+	}
+}
+
+func (r *SpssReader) displayParameters(data []byte, count int) {
+	if len(r.records) == 0 {
+		return
+	}
+
+	fields := count / len(r.records)
+	if fields != 2 && fields != 3 {
+		return
+	}
+
+	for i, rec := range r.records {
+		rec.measure = int32(r.order.Uint32(data[i*fields*4:]))
+	}
+}
+
+// Find the variable record given a long or a short name
+func (r *SpssReader) findRecord(name string) *variableRecord {
+	for _, rec := range r.records {
+		if strings.EqualFold(rec.name, name) {
+			return rec
+		}
+	}
+	for _, rec := range r.records {
+		if strings.EqualFold(rec.shortName, name) {
+			return rec
+		}
+	}
+	return nil
+}
+
+func (r *SpssReader) longVariableNames(data []byte) {
+	for _, pair := range strings.Split(string(data), "\t") {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		for _, rec := range r.records {
+			if strings.EqualFold(rec.shortName, parts[0]) {
+				rec.name = parts[1]
+				break
+			}
+		}
+	}
+}
+
+func (r *SpssReader) veryLongStrings(data []byte) {
+	for _, pair := range strings.Split(string(data), "\t") {
+		parts := strings.SplitN(strings.Trim(pair, "\x00"), "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		width, err := strconv.Atoi(parts[1])
+		if err != nil {
+			r.setErr(fmt.Errorf("Invalid very long string width %q", parts[1]))
+			return
+		}
+		for _, rec := range r.records {
+			if strings.EqualFold(rec.shortName, parts[0]) {
+				rec.longWidth = int32(width)
+				break
+			}
+		}
+	}
+}
+
+func (r *SpssReader) variableAttributes(data string) {
+	for pos := 0; pos < len(data); {
+		colon := strings.IndexByte(data[pos:], ':')
+		if colon < 0 {
+			r.setErr(fmt.Errorf("Invalid variable attributes, missing : after %q", data[pos:]))
+			return
+		}
+		name := data[pos : pos+colon]
+
+		attributes, end, err := parseAttributes(data, pos+colon+1)
+		if err != nil {
+			r.setErr(fmt.Errorf("Invalid attributes of variable %s: %v", name, err))
+			return
+		}
+		if rec := r.findRecord(name); rec != nil {
+			rec.attributes = attributes
+		}
+
+		pos = end + 1 // skip the slash between variables
+	}
+}
+
+// Read a length prefixed string from the data of an extension record
+func (r *SpssReader) lengthString(data []byte, pos *int) string {
+	if *pos+4 > len(data) {
+		*pos = len(data) + 1
+		return ""
+	}
+	l := int(int32(r.order.Uint32(data[*pos:])))
+	*pos += 4
+	if l < 0 || *pos+l > len(data) {
+		*pos = len(data) + 1
+		return ""
+	}
+	s := string(data[*pos : *pos+l])
+	*pos += l
+	return s
+}
+
+func (r *SpssReader) longStringValueLabels(data []byte) {
+	pos := 0
+	for pos < len(data) {
+		name := r.lengthString(data, &pos)
+		if pos+8 > len(data) {
+			r.setErr(fmt.Errorf("Invalid long string value labels record"))
+			return
+		}
+		n := int(int32(r.order.Uint32(data[pos+4:]))) // n_labels, after var_width
+		pos += 8
+
+		var labels []rawLabel
+		for i := 0; i < n && pos <= len(data); i++ {
+			value := r.lengthString(data, &pos)
+			desc := r.lengthString(data, &pos)
+			labels = append(labels, rawLabel{value: []byte(value), desc: desc})
+		}
+		if pos > len(data) {
+			r.setErr(fmt.Errorf("Invalid long string value labels record"))
+			return
+		}
+
+		if rec := r.findRecord(name); rec != nil {
+			rec.labels = append(rec.labels, labels...)
+		}
+	}
+}
+
+func (r *SpssReader) longStringMissingValues(data []byte) {
+	pos := 0
+	for pos < len(data) {
+		name := r.lengthString(data, &pos)
+		if pos+5 > len(data) {
+			r.setErr(fmt.Errorf("Invalid long string missing values record"))
+			return
+		}
+		n := int(data[pos])
+		size := int(int32(r.order.Uint32(data[pos+1:])))
+		pos += 5
+		if size < 0 || pos+n*size > len(data) {
+			r.setErr(fmt.Errorf("Invalid long string missing values record"))
+			return
+		}
+
+		rec := r.findRecord(name)
+		for i := 0; i < n; i++ {
+			if rec != nil {
+				rec.missing = append(rec.missing, data[pos:pos+size])
+			}
+			pos += size
+		}
+		if rec != nil {
+			rec.missingCode = int32(len(rec.missing))
+		}
+	}
+}
+
+// Reassemble the variables from the variable records, joining the segments of
+// very long strings
+func (r *SpssReader) buildVariables() error {
+	for i := 0; i < len(r.records); {
+		rec := r.records[i]
+
+		V := Variable{
+			Name:    rec.name,
+			Measure: measureFromCode(rec.measure),
+			Label:   rec.label,
+		}
+		if V.Name == "" {
+			V.Name = rec.shortName
+		}
+
+		if rec.width == 0 {
+			V.setPrint(SpssFormat(rec.print>>16&0xff), int16(rec.print>>8&0xff), int8(rec.print&0xff))
+		} else {
+			V.Type = SpssTypeString
+			V.Format = SpssFormatA
+			if SpssFormat(rec.print>>16&0xff) == SpssFormatAHex {
+				V.Format = SpssFormatAHex
+			}
+			V.Width = int16(rec.width)
+			if rec.longWidth != 0 {
+				if rec.longWidth < 256 || rec.longWidth > 32767 {
+					return fmt.Errorf("Invalid width %d of very long string %s", rec.longWidth, V.Name)
+				}
+				V.Width = int16(rec.longWidth)
+			}
+		}
+
+		for _, l := range rec.labels {
+			V.Labels = append(V.Labels, Label{Value: r.rawValue(&V, l.value), Desc: l.desc})
+		}
+
+		V.MissingValues = r.missingValues(&V, rec)
+
+		for name, values := range rec.attributes {
+			if name == "$@Role" {
+				if len(values) == 0 {
+					continue
+				}
+				if code, err := strconv.Atoi(values[0]); err == nil && code >= 0 && code < len(roleCodes) {
+					V.Role = roleCodes[code]
+				}
+				continue
+			}
+			if V.Attributes == nil {
+				V.Attributes = make(map[string][]string)
+			}
+			V.Attributes[name] = values
+		}
+
+		// Every segment after the first is a string record of the expected width
+		layout := V.layout()
+		segments := int(layout.segments)
+		if i+segments > len(r.records) {
+			return fmt.Errorf("Missing segments of very long string %s", V.Name)
+		}
+		for se := 1; se < segments; se++ {
+			if width := r.records[i+se].width; width != layout.segmentWidth(se) {
+				return fmt.Errorf("Segment %d of very long string %s has width %d instead of %d", se+1, V.Name, width, layout.segmentWidth(se))
+			}
+		}
+		i += segments
+
+		r.variables = append(r.variables, V)
+		r.layout = append(r.layout, layout)
+	}
+
+	// Multiple response sets list the short names of their variables
+	for _, set := range r.mrsets {
+		for i, name := range set.Variables {
+			for _, rec := range r.records {
+				if strings.EqualFold(rec.shortName, name) {
+					if set.Variables[i] = rec.name; rec.name == "" {
+						set.Variables[i] = rec.shortName
+					}
+					break
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// Convert a raw value of a label or missing value to its string representation
+func (r *SpssReader) rawValue(V *Variable, b []byte) string {
+	if V.Type == SpssTypeString {
+		return strings.TrimRight(string(b), " ")
+	}
+	return formatNumber(V.getPrint(), bytesToFloat64(r.order, b))
+}
+
+func (r *SpssReader) missingValues(V *Variable, rec *variableRecord) MissingValues {
+	var m MissingValues
+
+	raw := rec.missing
+	if rec.missingCode < 0 && len(raw) >= 2 {
+		m.Range = &MissingRange{
+			Low:  formatMissingBound(V.getPrint(), bytesToFloat64(r.order, raw[0])),
+			High: formatMissingBound(V.getPrint(), bytesToFloat64(r.order, raw[1])),
+		}
+		raw = raw[2:]
+	}
+
+	for _, b := range raw {
+		m.Values = append(m.Values, r.rawValue(V, b))
+	}
+
+	return m
+}
+
+func measureFromCode(code int32) SpssMeasure {
+	switch code {
+	case 1:
+		return SpssMeasureNominal
+	case 2:
+		return SpssMeasureOrdinal
+	case 3:
+		return SpssMeasureScale
+	default:
+		return ""
+	}
+}
+
+// ReadRow - Read the next case with the values formatted the way AddValueRow accepts them,
+// system-missing values are left out. Returns io.EOF after the last case.
+func (r *SpssReader) ReadRow() (map[string]string, error) {
+	if !r.decoder.Next() {
+		if err := r.decoder.Err(); err != nil {
+			return nil, err
+		}
+		return nil, io.EOF
+	}
+
+	return r.decoder.Row().Values(), nil
+}
@@ -0,0 +1,375 @@
+package gospss
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// memFile is an in-memory io.WriteSeeker
+type memFile struct {
+	data []byte
+	pos  int64
+}
+
+func (m *memFile) Write(p []byte) (int, error) {
+	if end := int(m.pos) + len(p); end > len(m.data) {
+		m.data = append(m.data, make([]byte, end-len(m.data))...)
+	}
+	copy(m.data[m.pos:], p)
+	m.pos += int64(len(p))
+	return len(p), nil
+}
+
+func (m *memFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+		m.pos = offset
+	case io.SeekCurrent:
+		m.pos += offset
+	case io.SeekEnd:
+		m.pos = int64(len(m.data)) + offset
+	}
+	if m.pos < 0 {
+		return 0, fmt.Errorf("Negative position")
+	}
+	return m.pos, nil
+}
+
+// Very long string of 699 bytes, stored in three segments
+var essay = strings.Repeat("Lorem ipsum dolor sit amet. ", 25)[:699]
+
+func testVariables() []Variable {
+	return []Variable{
+		{
+			Name:    "ID",
+			Type:    SpssTypeNumeric,
+			Measure: SpssMeasureNominal,
+			Width:   8,
+			Label:   "Respondent",
+			Labels:  []Label{{Value: "1", Desc: "First"}},
+			MissingValues: MissingValues{
+				Values: []string{"99"},
+				Range:  &MissingRange{Low: "LO", High: "-1"},
+			},
+			Attributes: map[string][]string{"QuestionText": {"How old's the respondent?"}},
+		},
+		{
+			Name:          "NAME",
+			Type:          SpssTypeString,
+			Width:         20,
+			Labels:        []Label{{Value: "NA", Desc: "Not answered"}},
+			MissingValues: MissingValues{Values: []string{"NA"}},
+			Role:          SpssRoleTarget,
+		},
+		{Name: "ESSAY", Type: SpssTypeString, Width: 700},
+		{Name: "Q5_BRAND_A", Type: SpssTypeNumeric, Width: 1},
+		{Name: "Q5_BRAND_B", Type: SpssTypeNumeric, Width: 1},
+		{Name: "SCORE", Type: SpssTypeNumeric, Width: 8, Decimal: 2},
+	}
+}
+
+var testRows = []map[string]string{
+	{"ID": "1", "NAME": "John", "ESSAY": essay, "Q5_BRAND_A": "1", "Q5_BRAND_B": "0", "SCORE": "12.25"},
+	{"ID": "2", "NAME": "NA", "ESSAY": "", "Q5_BRAND_A": "0", "SCORE": "-3.5"},
+	{"ID": "99", "NAME": "", "ESSAY": "short"},
+}
+
+// Write the test variables and rows, stream writes an io.Writer that cannot seek
+func writeTestFile(t *testing.T, compression SpssCompression, stream bool, options ...WriterOption) []byte {
+	t.Helper()
+
+	options = append([]WriterOption{WithCompression(compression)}, options...)
+
+	var spssWriter *SpssWriter
+	var err error
+	file := &memFile{}
+	var buf bytes.Buffer
+	if stream {
+		spssWriter, err = NewSpssStreamWriter(&buf, options...)
+	} else {
+		spssWriter, err = NewSpssWriter(file, append(options, WithFileLabel("Test file"))...)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, V := range testVariables() {
+		V := V
+		if err := spssWriter.AddVariable(&V); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := spssWriter.SetFileAttribute("Source", "It's 'quoted'"); err != nil {
+		t.Fatal(err)
+	}
+	if err := spssWriter.AddDocument("Fieldwork March 2021"); err != nil {
+		t.Fatal(err)
+	}
+	err = spssWriter.AddMultipleResponseSet(MultipleResponseSet{
+		Name:         "brands",
+		Type:         SpssMRSetDichotomy,
+		Label:        "Brands heard of",
+		CountedValue: "1",
+		Variables:    []string{"Q5_BRAND_A", "Q5_BRAND_B"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, row := range testRows {
+		if err := spssWriter.AddValueRow(row); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := spssWriter.Finish(); err != nil {
+		t.Fatal(err)
+	}
+
+	if stream {
+		return buf.Bytes()
+	}
+	return file.data
+}
+
+func TestReaderRoundTrip(t *testing.T) {
+	tests := []struct {
+		name        string
+		compression SpssCompression
+		stream      bool
+		ncases      int64
+	}{
+		{"none", SpssCompressionNone, false, 3},
+		{"bytecode", SpssCompressionBytecode, false, 3},
+		{"zlib", SpssCompressionZlib, false, 3},
+		{"stream", SpssCompressionBytecode, true, -1},
+		{"stream none", SpssCompressionNone, true, -1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := writeTestFile(t, tt.compression, tt.stream)
+
+			r, err := NewSpssReader(bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if r.Compression() != tt.compression {
+				t.Errorf("Compression() = %d, want %d", r.Compression(), tt.compression)
+			}
+			if r.NumberOfCases() != tt.ncases {
+				t.Errorf("NumberOfCases() = %d, want %d", r.NumberOfCases(), tt.ncases)
+			}
+
+			// ID, SCORE and the Q5 variables take 1 element each, NAME 3 and the segments of ESSAY 32, 32 and 25
+			if size := int32(binary.LittleEndian.Uint32(data[headerCaseSizeOffset:])); size != 96 {
+				t.Errorf("nominal_case_size = %d, want 96", size)
+			}
+
+			checkVariables(t, r.Variables())
+
+			if want := []string{"Fieldwork March 2021"}; !reflect.DeepEqual(r.Documents(), want) {
+				t.Errorf("Documents() = %q, want %q", r.Documents(), want)
+			}
+			if want := map[string][]string{"Source": {"It's 'quoted'"}}; !reflect.DeepEqual(r.FileAttributes(), want) {
+				t.Errorf("FileAttributes() = %q, want %q", r.FileAttributes(), want)
+			}
+			// Quotes in attribute values are written verbatim, the way PSPP reads them
+			if !bytes.Contains(data, []byte("Source('It's 'quoted''\n)")) {
+				t.Error("File attribute is not written verbatim")
+			}
+
+			wantSets := []MultipleResponseSet{{
+				Name:         "$brands",
+				Type:         SpssMRSetDichotomy,
+				Label:        "Brands heard of",
+				CountedValue: "1",
+				Variables:    []string{"Q5_BRAND_A", "Q5_BRAND_B"},
+			}}
+			if !reflect.DeepEqual(r.MultipleResponseSets(), wantSets) {
+				t.Errorf("MultipleResponseSets() = %+v, want %+v", r.MultipleResponseSets(), wantSets)
+			}
+
+			checkRows(t, r)
+		})
+	}
+}
+
+func checkVariables(t *testing.T, variables []Variable) {
+	t.Helper()
+
+	want := testVariables()
+	if len(variables) != len(want) {
+		t.Fatalf("Read %d variables, want %d", len(variables), len(want))
+	}
+
+	for i, got := range variables {
+		w := want[i]
+		if got.Name != w.Name || got.Type != w.Type || got.Width != w.Width || got.Decimal != w.Decimal {
+			t.Errorf("Variable %d = %s %s %d.%d, want %s %s %d.%d", i, got.Name, got.Type, got.Width, got.Decimal,
+				w.Name, w.Type, w.Width, w.Decimal)
+		}
+		if got.Label != w.Label || got.Role != w.Role {
+			t.Errorf("Variable %s has label %q and role %q, want %q and %q", got.Name, got.Label, got.Role, w.Label, w.Role)
+		}
+		if len(got.Labels) != 0 || len(w.Labels) != 0 {
+			if !reflect.DeepEqual(got.Labels, w.Labels) {
+				t.Errorf("Variable %s has labels %v, want %v", got.Name, got.Labels, w.Labels)
+			}
+		}
+		if !reflect.DeepEqual(got.MissingValues.Range, w.MissingValues.Range) ||
+			len(got.MissingValues.Values) != len(w.MissingValues.Values) ||
+			len(w.MissingValues.Values) > 0 && !reflect.DeepEqual(got.MissingValues.Values, w.MissingValues.Values) {
+			t.Errorf("Variable %s has missing values %+v, want %+v", got.Name, got.MissingValues, w.MissingValues)
+		}
+		if len(got.Attributes) != 0 || len(w.Attributes) != 0 {
+			if !reflect.DeepEqual(got.Attributes, w.Attributes) {
+				t.Errorf("Variable %s has attributes %q, want %q", got.Name, got.Attributes, w.Attributes)
+			}
+		}
+	}
+}
+
+func checkRows(t *testing.T, r interface {
+	ReadRow() (map[string]string, error)
+}) {
+	t.Helper()
+
+	for i, want := range testRows {
+		got, err := r.ReadRow()
+		if err != nil {
+			t.Fatalf("Row %d: %v", i+1, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Row %d = %q, want %q", i+1, got, want)
+		}
+	}
+
+	if _, err := r.ReadRow(); err != io.EOF {
+		t.Errorf("ReadRow() after the last row returned %v, want io.EOF", err)
+	}
+}
+
+// Returns the position of the first record of the given type and subtype, walking
+// the dictionary record by record
+func findRecord(t *testing.T, data []byte, recType int32, subtype int32) int {
+	t.Helper()
+
+	at := func(pos int) int {
+		return int(int32(binary.LittleEndian.Uint32(data[pos:])))
+	}
+
+	for pos := 176; pos+8 <= len(data); {
+		rt := int32(at(pos))
+		if rt == recType && (recType != 7 || int32(at(pos+4)) == subtype) {
+			return pos
+		}
+
+		switch rt {
+		case 2:
+			next := pos + 32
+			if at(pos+8) == 1 {
+				next += 4 + (at(next)+3)/4*4
+			}
+			missing := at(pos + 12)
+			if missing < 0 {
+				missing = -missing
+			}
+			pos = next + missing*8
+		case 3:
+			next := pos + 8
+			for i := 0; i < at(pos+4); i++ {
+				next += 8 + (int(data[next+8])+8)/8*8
+			}
+			pos = next
+		case 4:
+			pos += 8 + 4*at(pos+4)
+		case 6:
+			pos += 8 + 80*at(pos+4)
+		case 7:
+			pos += 16 + at(pos+8)*at(pos+12)
+		default:
+			t.Fatalf("Record %d subtype %d not found", recType, subtype)
+		}
+	}
+	t.Fatalf("Record %d subtype %d not found", recType, subtype)
+	return 0
+}
+
+func TestReaderMalformed(t *testing.T) {
+	valid := writeTestFile(t, SpssCompressionBytecode, false)
+
+	corrupt := func(f func(data []byte)) []byte {
+		data := append([]byte(nil), valid...)
+		f(data)
+		return data
+	}
+	putInt32 := func(data []byte, pos int, i int32) {
+		binary.LittleEndian.PutUint32(data[pos:], uint32(i))
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+		err  string
+	}{
+		{"empty", nil, "Cannot read header"},
+		{"not spss", bytes.Repeat([]byte("x"), 200), "Not an SPSS file"},
+		{"truncated header", valid[:100], "Cannot read header"},
+		{"truncated dictionary", valid[:400], "unexpected EOF"},
+		{"negative label count", corrupt(func(data []byte) {
+			putInt32(data, findRecord(t, data, 3, 0)+4, -1)
+		}), "Invalid label count -1"},
+		{"huge label count", corrupt(func(data []byte) {
+			putInt32(data, findRecord(t, data, 3, 0)+4, 1<<30)
+		}), "Cannot read record type 3: unexpected EOF"},
+		{"negative extension count", corrupt(func(data []byte) {
+			putInt32(data, findRecord(t, data, 7, 3)+12, -8)
+		}), "Invalid size 4 and count -8"},
+		{"huge extension record", corrupt(func(data []byte) {
+			putInt32(data, findRecord(t, data, 7, 3)+12, 1<<29)
+		}), "Cannot read record type 7: unexpected EOF"},
+		{"unknown record", corrupt(func(data []byte) {
+			putInt32(data, findRecord(t, data, 6, 0), 5)
+		}), "Unknown record type 5"},
+		{"very long string too wide", bytes.Replace(valid, []byte("ESSAY=00700"), []byte("ESSAY=40000"), 1),
+			"Invalid width 40000 of very long string ESSAY"},
+		{"very long string too narrow", bytes.Replace(valid, []byte("ESSAY=00700"), []byte("ESSAY=00100"), 1),
+			"Invalid width 100 of very long string ESSAY"},
+		{"very long string segments", bytes.Replace(valid, []byte("ESSAY=00700"), []byte("ESSAY=00900"), 1),
+			"Segment 3 of very long string ESSAY has width 196 instead of 255"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewSpssReader(bytes.NewReader(tt.data))
+			if err == nil {
+				t.Fatalf("NewSpssReader() succeeded, want error containing %q", tt.err)
+			}
+			if !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("NewSpssReader() error = %q, want it to contain %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestReaderTruncatedCases(t *testing.T) {
+	valid := writeTestFile(t, SpssCompressionNone, false)
+
+	r, err := NewSpssReader(bytes.NewReader(valid[:len(valid)-20]))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := r.Cases()
+	for cases.Next() {
+	}
+	if cases.Err() == nil {
+		t.Error("Reading truncated cases succeeded, want an error")
+	}
+}
@@ -0,0 +1,166 @@
+package gospss
+
+import (
+	"fmt"
+	"time"
+)
+
+// Row holds typed values by variable name, variables that are left out are
+// written as system-missing or as spaces
+type Row map[string]Datum
+
+// Kind of the value held by a Datum
+type datumKind int
+
+const (
+	datumSystemMissing datumKind = iota
+	datumUserMissing
+	datumNumber
+	datumText
+	datumTime
+)
+
+// Datum defines a single typed value of a row, create it with Number, Text, Time,
+// SystemMissing or UserMissing
+type Datum struct {
+	kind   datumKind
+	number float64
+	text   string
+	time   time.Time
+}
+
+// Number - Returns a value for a numeric, date or datetime variable, dates are given in seconds
+// since 14 October 1582
+func Number(f float64) Datum {
+	return Datum{kind: datumNumber, number: f}
+}
+
+// Text - Returns a value for a string variable
+func Text(s string) Datum {
+	return Datum{kind: datumText, text: s}
+}
+
+// Time - Returns a value for a date or datetime variable, the wall clock of t is stored
+// since SPSS has no time zones and dates leave out the time of day
+func Time(t time.Time) Datum {
+	return Datum{kind: datumTime, time: t}
+}
+
+// SystemMissing - Returns the system-missing value for a numeric variable, string
+// variables are written as spaces
+func SystemMissing() Datum {
+	return Datum{kind: datumSystemMissing}
+}
+
+// UserMissing - Returns the first user-missing value of the variable, which must have one
+func UserMissing() Datum {
+	return Datum{kind: datumUserMissing}
+}
+
+// AddRow - Add a row of typed values to the SPSS file, nothing is written when a value does
+// not fit its variable
+// CAUTION: All variables must be written before adding values
+func (s *SpssWriter) AddRow(row Row) error {
+	if s.err != nil {
+		return s.err
+	}
+
+	for name := range row {
+		if _, found := s.lookup[name]; !found {
+			return fmt.Errorf("Cannot add value for unknown variable %s", name)
+		}
+	}
+
+	// Convert every value before writing so a row is never written halfway
+	numbers := make([]float64, len(s.variables))
+	texts := make([]string, len(s.variables))
+	for i, v := range s.variables {
+		var err error
+		if v.spssType == SpssTypeString {
+			texts[i], err = v.datumText(row[v.name])
+		} else {
+			numbers[i], err = v.datumNumber(row[v.name])
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if !s.infoWritten {
+		s.writeInfoRecords()
+	}
+
+	for i, v := range s.variables {
+		if v.spssType == SpssTypeString {
+			s.setErr(s.writeString(v, texts[i]))
+		} else if numbers[i] == sysmis {
+			s.setErr(s.cases.WriteMissing())
+		} else {
+			s.setErr(s.cases.WriteNumber(numbers[i]))
+		}
+	}
+
+	if s.err != nil {
+		return s.err
+	}
+
+	s.valCount++
+	return nil
+}
+
+// Value of a numeric variable, a Datum that was left out is system-missing
+func (v *variable) datumNumber(d Datum) (float64, error) {
+	switch d.kind {
+	case datumSystemMissing:
+		return sysmis, nil
+	case datumNumber:
+		return d.number, nil
+	case datumTime:
+		if !v.format.isDate() {
+			return 0, fmt.Errorf("Cannot write a time to variable %s with format %s", v.name, v.format)
+		}
+		return timeToNumber(v.format, d.time), nil
+	case datumUserMissing:
+		if len(v.missing) == 0 {
+			return 0, fmt.Errorf("Cannot write a user-missing value to variable %s, it has no missing values", v.name)
+		}
+		if v.missingCode < 0 {
+			// Discrete value after the range, otherwise the bound of the range that is not an extreme
+			if len(v.missing) > 2 {
+				return v.missing[2], nil
+			}
+			if v.missing[0] <= lowest {
+				return v.missing[1], nil
+			}
+		}
+		return v.missing[0], nil
+	default:
+		return 0, fmt.Errorf("Cannot write text to variable %s of type %s", v.name, v.spssType)
+	}
+}
+
+// Value of a string variable, a Datum that was left out is written as spaces
+func (v *variable) datumText(d Datum) (string, error) {
+	switch d.kind {
+	case datumSystemMissing:
+		return "", nil
+	case datumText:
+		return trim(d.text, int(v.width)), nil
+	case datumUserMissing:
+		if len(v.missingStrings) == 0 {
+			return "", fmt.Errorf("Cannot write a user-missing value to variable %s, it has no missing values", v.name)
+		}
+		return v.missingStrings[0], nil
+	default:
+		return "", fmt.Errorf("Cannot write a number to string variable %s", v.name)
+	}
+}
+
+// Seconds since the start of the Gregorian calendar of the wall clock of d, formats
+// without time of day get midnight
+func timeToNumber(f SpssFormat, d time.Time) float64 {
+	if f.family() == familyDate {
+		return dateToNumber(time.Date(d.Year(), d.Month(), d.Day(), 0, 0, 0, 0, time.UTC))
+	}
+	return dateToNumber(time.Date(d.Year(), d.Month(), d.Day(), d.Hour(), d.Minute(), d.Second(), d.Nanosecond(), time.UTC))
+}
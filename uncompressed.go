@@ -0,0 +1,55 @@
+package gospss
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+type uncompressedWriter struct {
+	io.Writer
+}
+
+func newUncompressedWriter(w io.Writer) *uncompressedWriter {
+	return &uncompressedWriter{Writer: w}
+}
+
+func (w *uncompressedWriter) WriteMissing() error {
+	return binary.Write(w, endian, sysmis)
+}
+
+func (w *uncompressedWriter) WriteNumber(number float64) error {
+	return binary.Write(w, endian, number)
+}
+
+func (w *uncompressedWriter) WriteString(val string, elements int) error {
+	_, err := w.Write(stob(val, elements*8))
+	return err
+}
+
+func (w *uncompressedWriter) Flush() error {
+	return nil
+}
+
+// uncompressedReader is the inverse of uncompressedWriter
+type uncompressedReader struct {
+	io.Reader
+	order binary.ByteOrder
+}
+
+func newUncompressedReader(r io.Reader, order binary.ByteOrder) *uncompressedReader {
+	return &uncompressedReader{Reader: r, order: order}
+}
+
+func (r *uncompressedReader) ReadNumber() (float64, error) {
+	var number float64
+	err := binary.Read(r.Reader, r.order, &number)
+	return number, err
+}
+
+func (r *uncompressedReader) ReadString(elements int) (string, error) {
+	buf := make([]byte, elements*8)
+	if _, err := io.ReadFull(r.Reader, buf); err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
@@ -0,0 +1,176 @@
+package gospss
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+)
+
+// Decoder fills structs with cases, the fields are matched to variables by their
+// spss tags or by their names when they have no tag
+type Decoder struct {
+	cases  *CaseDecoder  // Decoder of the cases
+	typ    reflect.Type  // Type of the struct, nil until the fields are matched
+	fields []structField // Fields to fill
+	vars   []int         // Position in the case of the variable of each field
+}
+
+// NewDecoder - Returns a decoder of the cases of an SpssReader or PorReader
+func NewDecoder(cases *CaseDecoder) *Decoder {
+	return &Decoder{cases: cases}
+}
+
+// Unmarshal - Read all cases of an SPSS file into a pointer to a slice of structs,
+// or pointers to structs
+func Unmarshal(r io.Reader, rows interface{}) error {
+	v := reflect.ValueOf(rows)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Slice {
+		return fmt.Errorf("Cannot unmarshal into %T, expected a pointer to a slice of structs", rows)
+	}
+
+	spssReader, err := NewSpssReader(r)
+	if err != nil {
+		return err
+	}
+
+	slice := v.Elem()
+	elem := slice.Type().Elem()
+	pointer := elem.Kind() == reflect.Ptr
+	if pointer {
+		elem = elem.Elem()
+	}
+
+	d := NewDecoder(spssReader.Cases())
+	for {
+		row := reflect.New(elem)
+		if err := d.Decode(row.Interface()); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if pointer {
+			slice.Set(reflect.Append(slice, row))
+		} else {
+			slice.Set(reflect.Append(slice, row.Elem()))
+		}
+	}
+}
+
+// Decode - Read the next case into a pointer to a struct, returns io.EOF after the last case.
+// System-missing values leave pointers nil and other fields at their zero value
+func (d *Decoder) Decode(row interface{}) error {
+	v := reflect.ValueOf(row)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return fmt.Errorf("Cannot decode into %T, expected a pointer to a struct", row)
+	}
+	v = v.Elem()
+
+	if d.typ == nil {
+		if err := d.matchFields(v.Type()); err != nil {
+			return err
+		}
+	}
+
+	if v.Type() != d.typ {
+		return fmt.Errorf("Cannot decode into %s, the decoder fills %s", v.Type(), d.typ)
+	}
+
+	if !d.cases.Next() {
+		if err := d.cases.Err(); err != nil {
+			return err
+		}
+		return io.EOF
+	}
+
+	c := d.cases.Row()
+	for i := range d.fields {
+		f := &d.fields[i]
+		if err := f.decode(c, d.vars[i], v.Field(f.index)); err != nil {
+			return fmt.Errorf("Cannot decode variable %s of case %d into field %s: %v",
+				f.variable.Name, d.cases.count, d.typ.Field(f.index).Name, err)
+		}
+	}
+
+	return nil
+}
+
+func (d *Decoder) matchFields(t reflect.Type) error {
+	fields, err := structFields(t)
+	if err != nil {
+		return err
+	}
+
+	lookup := d.cases.Row().lookup
+	vars := make([]int, len(fields))
+	for i, f := range fields {
+		index, found := lookup[f.variable.Name]
+		if !found {
+			return fmt.Errorf("Cannot find variable %s for field %s", f.variable.Name, t.Field(f.index).Name)
+		}
+		vars[i] = index
+	}
+
+	d.typ = t
+	d.fields = fields
+	d.vars = vars
+	return nil
+}
+
+// Set the field to the value of the variable at the given position in the case
+func (f *structField) decode(c *Case, index int, field reflect.Value) error {
+	v := c.layout[index]
+
+	if v.spssType == SpssTypeString {
+		if f.kind != reflect.String {
+			return fmt.Errorf("String variable does not fit %s", field.Type())
+		}
+		f.target(field).SetString(c.strings[index])
+		return nil
+	}
+
+	number := c.numbers[index]
+	if number == c.sysmis {
+		field.Set(reflect.Zero(field.Type()))
+		return nil
+	}
+
+	switch {
+	case f.variable.Type == SpssTypeDate || f.variable.Type == SpssTypeDatetime:
+		if !v.format.isDate() {
+			return fmt.Errorf("Variable with format %s does not fit %s", v.format, field.Type())
+		}
+		t, _ := c.Time(v.name)
+		f.target(field).Set(reflect.ValueOf(t))
+	case f.kind == reflect.String:
+		return fmt.Errorf("Variable of type %s does not fit %s", v.spssType, field.Type())
+	case f.kind == reflect.Bool:
+		f.target(field).SetBool(number != 0)
+	case f.kind == reflect.Float32 || f.kind == reflect.Float64:
+		f.target(field).SetFloat(number)
+	case f.kind >= reflect.Int && f.kind <= reflect.Int64:
+		target := f.target(field)
+		if number != math.Trunc(number) || math.Abs(number) >= 1<<63 || target.OverflowInt(int64(number)) {
+			return fmt.Errorf("Value %v does not fit %s", number, field.Type())
+		}
+		target.SetInt(int64(number))
+	default:
+		target := f.target(field)
+		if number != math.Trunc(number) || number < 0 || number >= 1<<64 || target.OverflowUint(uint64(number)) {
+			return fmt.Errorf("Value %v does not fit %s", number, field.Type())
+		}
+		target.SetUint(uint64(number))
+	}
+
+	return nil
+}
+
+// Returns the value to set, a pointer field gets a new value to point to
+func (f *structField) target(field reflect.Value) reflect.Value {
+	if !f.pointer {
+		return field
+	}
+	field.Set(reflect.New(field.Type().Elem()))
+	return field.Elem()
+}
@@ -0,0 +1,162 @@
+package gospss
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Returns the file written by Marshal for the rows
+func marshalTestFile(t *testing.T, rows interface{}) []byte {
+	t.Helper()
+
+	file := &memFile{}
+	if err := Marshal(file, rows); err != nil {
+		t.Fatal(err)
+	}
+	return file.data
+}
+
+func newTestSpssReader(t *testing.T, data []byte) *SpssReader {
+	t.Helper()
+
+	r, err := NewSpssReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestUnmarshal(t *testing.T) {
+	data := marshalTestFile(t, testPeople())
+
+	// Strings are cut off at their width and fields without a variable keep their zero value
+	want := testPeople()
+	want[0].Comment = want[0].Comment[:40]
+	want[0].Notes, want[0].secret = "", ""
+
+	var people []testPerson
+	if err := Unmarshal(bytes.NewReader(data), &people); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(people, want) {
+		t.Errorf("Unmarshal() = %+v, want %+v", people, want)
+	}
+
+	var pointers []*testPerson
+	if err := Unmarshal(bytes.NewReader(data), &pointers); err != nil {
+		t.Fatal(err)
+	}
+	if len(pointers) != len(want) {
+		t.Fatalf("Unmarshal() returned %d pointers, want %d", len(pointers), len(want))
+	}
+	for i := range want {
+		if !reflect.DeepEqual(*pointers[i], want[i]) {
+			t.Errorf("Unmarshal() row %d = %+v, want %+v", i+1, *pointers[i], want[i])
+		}
+	}
+}
+
+func TestUnmarshalSystemMissing(t *testing.T) {
+	one := 1.5
+	data := marshalTestFile(t, []struct {
+		A *float64 `spss:"VAL_A"`
+		B *float64 `spss:"VAL_B"`
+	}{{&one, &one}, {nil, nil}})
+
+	// Pointers stay nil and values are set to zero, also when the struct is reused
+	var row struct {
+		A *float64 `spss:"VAL_A"`
+		B float64  `spss:"VAL_B"`
+	}
+	d := NewDecoder(newTestSpssReader(t, data).Cases())
+	if err := d.Decode(&row); err != nil {
+		t.Fatal(err)
+	}
+	if row.A == nil || *row.A != 1.5 || row.B != 1.5 {
+		t.Errorf("Decode() of case 1 = %+v", row)
+	}
+	if err := d.Decode(&row); err != nil {
+		t.Fatal(err)
+	}
+	if row.A != nil || row.B != 0 {
+		t.Errorf("Decode() of a system-missing case = %+v, want a nil pointer and 0", row)
+	}
+	if err := d.Decode(&row); err != io.EOF {
+		t.Errorf("Decode() after the last case returned %v, want io.EOF", err)
+	}
+}
+
+func TestDecoderMismatch(t *testing.T) {
+	data := marshalTestFile(t, []struct {
+		X float64 `spss:"NUM"`
+		S string  `spss:"TEXT,width=5"`
+	}{{1, "a"}, {300, "b"}, {1.5, "c"}})
+
+	tests := []struct {
+		name string
+		row  interface{}
+		err  string
+	}{
+		{"fraction into int", &struct {
+			X int `spss:"NUM"`
+		}{}, "Cannot decode variable NUM of case 3 into field X: Value 1.5 does not fit int"},
+		{"overflow", &struct {
+			Value *uint8 `spss:"NUM"`
+		}{}, "Cannot decode variable NUM of case 2 into field Value: Value 300 does not fit *uint8"},
+		{"string into number", &struct {
+			Number float64 `spss:"TEXT"`
+		}{}, "Cannot decode variable TEXT of case 1 into field Number: String variable does not fit float64"},
+		{"number into string", &struct {
+			Text string `spss:"NUM"`
+		}{}, "Cannot decode variable NUM of case 1 into field Text: Variable of type NUMERIC does not fit string"},
+		{"number into time", &struct {
+			When time.Time `spss:"NUM"`
+		}{}, "Cannot decode variable NUM of case 1 into field When: Variable with format F does not fit time.Time"},
+		{"unknown variable", &struct {
+			Y int `spss:"OTHER"`
+		}{}, "Cannot find variable OTHER for field Y"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDecoder(newTestSpssReader(t, data).Cases())
+			var err error
+			for err == nil {
+				err = d.Decode(tt.row)
+			}
+			if err == io.EOF || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("Decode() error = %v, want it to contain %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestDecoderInvalid(t *testing.T) {
+	data := marshalTestFile(t, testPeople())
+
+	var people []testPerson
+	if err := Unmarshal(bytes.NewReader(data), people); err == nil {
+		t.Error("Unmarshal() into a slice instead of a pointer succeeded")
+	}
+	if err := Unmarshal(bytes.NewReader(data), &testPerson{}); err == nil {
+		t.Error("Unmarshal() into a pointer to a struct succeeded")
+	}
+
+	d := NewDecoder(newTestSpssReader(t, data).Cases())
+	if err := d.Decode(testPerson{}); err == nil {
+		t.Error("Decode() into a struct instead of a pointer succeeded")
+	}
+	if err := d.Decode((*testPerson)(nil)); err == nil {
+		t.Error("Decode() into a nil pointer succeeded")
+	}
+	if err := d.Decode(&testPerson{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Decode(&struct{ ID int }{}); err == nil {
+		t.Error("Decode() into another type succeeded")
+	}
+}
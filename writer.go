@@ -6,9 +6,6 @@ import (
 	"encoding/binary"
 	"fmt"
 	"io"
-	"log"
-	"math"
-	"os"
 	"strconv"
 	"strings"
 	"time"
@@ -18,41 +15,234 @@ var endian = binary.LittleEndian
 
 const TimeOffset = 12219379200
 
+// Bias of the bytecode compression
+const compressionBias = 100.0
+
+// Start of the product name, SPSS does not open files without it
+const productPrefix = "@(#) SPSS DATA FILE"
+
+// Offsets of the header fields that depend on the dictionary or the cases
+const (
+	headerCaseSizeOffset    = 68 // nominal_case_size
+	headerWeightIndexOffset = 76 // weight_index
+	headerNCasesOffset      = 80 // ncases
+)
+
+// caseWriter writes the elements of the cases in the file's compression
+type caseWriter interface {
+	WriteMissing() error
+	WriteNumber(number float64) error
+	WriteString(val string, elements int) error
+	Flush() error
+}
+
+// WriterOption configures an SpssWriter before the header is written
+type WriterOption func(*SpssWriter) error
+
+// WithCompression - Set the way cases are stored, bytecode compression is the default
+func WithCompression(compression SpssCompression) WriterOption {
+	return func(s *SpssWriter) error {
+		switch compression {
+		case SpssCompressionNone, SpssCompressionBytecode, SpssCompressionZlib:
+			s.compression = compression
+			return nil
+		default:
+			return fmt.Errorf("Unsupported compression %d", compression)
+		}
+	}
+}
+
+// WithFileLabel - Set the label of the file, at most 64 bytes
+func WithFileLabel(label string) WriterOption {
+	return func(s *SpssWriter) error {
+		if len(label) > 64 {
+			return fmt.Errorf("Cannot set file label of %d bytes, the maximum is 64", len(label))
+		}
+		s.fileLabel = label
+		return nil
+	}
+}
+
+// WithProductName - Set the product that wrote the file, at most 60 bytes. The name starts
+// with "@(#) SPSS DATA FILE", which is added in front of the product when it is left out
+func WithProductName(product string) WriterOption {
+	return func(s *SpssWriter) error {
+		if !strings.HasPrefix(product, productPrefix) {
+			product = productPrefix + " " + product
+		}
+		if len(product) > 60 {
+			return fmt.Errorf("Cannot set product name of %d bytes, the maximum is 60 including %q", len(product), productPrefix)
+		}
+		s.product = product
+		return nil
+	}
+}
+
+// WithClock - Set the function that returns the creation date and time of the file,
+// a fixed time makes the output reproducible
+func WithClock(now func() time.Time) WriterOption {
+	return func(s *SpssWriter) error {
+		if now == nil {
+			return fmt.Errorf("Clock cannot be nil")
+		}
+		s.now = now
+		return nil
+	}
+}
+
 // SpssWriter defines the struct to write SPSS objects
 type SpssWriter struct {
-	*bufio.Writer                     // Buffered writer
-	seeker        io.WriteSeeker      // Original writer
-	bytecode      *bytecodeWriter     // Special writer for compressed cases
+	writer        *bufio.Writer       // Buffered writer
+	seeker        io.WriteSeeker      // Original writer, nil for streams
+	dictionary    *bytes.Buffer       // Dictionary held back until the header of a stream is written
+	counter       *countWriter        // Counts the bytes written to the original writer
+	cases         caseWriter          // Special writer for the cases
+	compression   SpssCompression     // Compression of the cases
+	zlib          *zlibWriter         // Block writer for ZSAV files
+	zheaderOffset int64               // Offset of the zheader in ZSAV files
 	names         map[string]string   // Mapping of names for easy access
 	count         int                 // Count of values
 	index         int32               // Writing index
 	endian        binary.ByteOrder    // Endian
-	variables     map[string]variable // Written variables
+	variables     []variable          // Written variables in declaration order
+	lookup        map[string]int      // Position of each variable in variables
 	valCount      int                 // Number of value rows
+	infoWritten   bool                // Info records and termination record are written
+	err           error               // First write error, returned by every later call
+	weightIndex   int32               // Dictionary index of the weight variable
+	documents     []string            // Document lines
+	product       string              // Product that writes the file
+	fileLabel     string              // Label of the file
+	now           func() time.Time    // Clock for the creation date and time
+	attributes    map[string][]string // Custom attributes of the file
+	mrsets        []string            // Multiple response sets as written in the file
 }
 
-// NewSpssWriter - Returns an SPSS Writer struct given a file
-func NewSpssWriter(file *os.File) (*SpssWriter, error) {
-	writer := bufio.NewWriter(file)
+// NewSpssWriter - Returns an SPSS Writer struct given a file or any other io.WriteSeeker,
+// the header is rewritten in Finish
+func NewSpssWriter(file io.WriteSeeker, options ...WriterOption) (*SpssWriter, error) {
+	counter := &countWriter{Writer: file}
 
-	byteCode := newBytecodeWriter(writer, 100.0)
+	spssWriter, err := newSpssWriter(bufio.NewWriter(counter), counter, options)
+	if err != nil {
+		return nil, err
+	}
 
+	spssWriter.seeker = file
+	spssWriter.headerRecord()
+
+	return spssWriter, nil
+}
+
+// NewSpssStreamWriter - Returns an SPSS Writer struct given an io.Writer that cannot seek.
+// The dictionary is held back until the first row so the header can be written at
+// once, the number of cases is unknown and written as -1.
+func NewSpssStreamWriter(w io.Writer, options ...WriterOption) (*SpssWriter, error) {
+	dictionary := new(bytes.Buffer)
+
+	spssWriter, err := newSpssWriter(bufio.NewWriter(dictionary), &countWriter{Writer: w}, options)
+	if err != nil {
+		return nil, err
+	}
+
+	if spssWriter.compression == SpssCompressionZlib {
+		return nil, fmt.Errorf("ZSAV files require an io.WriteSeeker")
+	}
+
+	spssWriter.dictionary = dictionary
+
+	return spssWriter, nil
+}
+
+func newSpssWriter(writer *bufio.Writer, counter *countWriter, options []WriterOption) (*SpssWriter, error) {
 	spssWriter := &SpssWriter{
-		seeker:    file,
-		Writer:    writer,
-		bytecode:  byteCode,
-		names:     make(map[string]string),
-		variables: make(map[string]variable),
-		index:     1,
-		endian:    binary.LittleEndian,
-		count:     0,
+		writer:      writer,
+		counter:     counter,
+		compression: SpssCompressionBytecode,
+		names:       make(map[string]string),
+		lookup:      make(map[string]int),
+		index:       1,
+		endian:      binary.LittleEndian,
+		count:       0,
+		product:     productPrefix + " - xml2sav 2.0",
+		fileLabel:   "Generated SPSS",
+		now:         time.Now,
 	}
 
-	spssWriter.headerRecord()
+	for _, option := range options {
+		if err := option(spssWriter); err != nil {
+			return nil, err
+		}
+	}
+
+	switch spssWriter.compression {
+	case SpssCompressionNone:
+		spssWriter.cases = newUncompressedWriter(writer)
+	case SpssCompressionZlib:
+		spssWriter.zlib = newZlibWriter(writer)
+		spssWriter.cases = newBytecodeWriter(spssWriter.zlib, compressionBias)
+	default:
+		spssWriter.cases = newBytecodeWriter(writer, compressionBias)
+	}
 
 	return spssWriter, nil
 }
 
+// countWriter keeps track of the number of bytes written
+type countWriter struct {
+	io.Writer
+	n int64
+}
+
+func (w *countWriter) Write(p []byte) (int, error) {
+	n, err := w.Writer.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+// Keep the first error that occurred
+func (s *SpssWriter) setErr(err error) {
+	if s.err == nil && err != nil {
+		s.err = err
+	}
+}
+
+// Write - Writes to the buffered writer, once a write failed every later call returns that error
+func (s *SpssWriter) Write(p []byte) (int, error) {
+	if s.err != nil {
+		return 0, s.err
+	}
+	n, err := s.writer.Write(p)
+	s.setErr(err)
+	return n, err
+}
+
+// Flush - Flushes the buffered writer, once a write failed every later call returns that error
+func (s *SpssWriter) Flush() error {
+	if s.err != nil {
+		return s.err
+	}
+	s.setErr(s.writer.Flush())
+	return s.err
+}
+
+// Overwrite data at the given offset of the original writer
+func (s *SpssWriter) patch(offset int64, data interface{}) {
+	if s.err != nil {
+		return
+	}
+	if _, err := s.seeker.Seek(offset, io.SeekStart); err != nil {
+		s.setErr(err)
+		return
+	}
+	s.setErr(binary.Write(s.seeker, endian, data))
+}
+
+// Offset in the file of the next byte written
+func (s *SpssWriter) offset() int64 {
+	return s.counter.n + int64(s.writer.Buffered())
+}
+
 func stob(s string, l int) []byte {
 	if len(s) > l {
 		s = s[:l]
@@ -82,14 +272,6 @@ func ftoa(f float64) string {
 	return strconv.FormatFloat(f, 'E', -1, 64)
 }
 
-func atof(s string) float64 {
-	v, err := strconv.ParseFloat(s, 32)
-	if err != nil {
-		log.Fatalln(err)
-	}
-	return v
-}
-
 func elementCount(width int32) int32 {
 	return ((width - 1) / 8) + 1
 }
@@ -106,27 +288,142 @@ func (s *SpssWriter) caseSize() int32 {
 
 func (s *SpssWriter) writeString(v variable, val string) error {
 	for se := 0; se < int(v.segments); se++ {
-		var p string
-		if len(val) > 255 {
-			p = val[:255]
-			val = val[255:]
-		} else {
-			p = val
-			val = ""
+		// Every segment but the last holds 252 bytes of the value
+		p := val
+		if se < int(v.segments)-1 {
+			p = trim(p, 252)
+			val = val[len(p):]
+		}
+
+		if err := s.cases.WriteString(p, int(elementCount(v.segmentWidth(se)))); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// SetWeight - Use a numeric variable to weight the cases, the variable must be added first.
+// Files written by the stream writer need the weight before values are added
+func (s *SpssWriter) SetWeight(name string) error {
+	if s.err != nil {
+		return s.err
+	}
+
+	i, found := s.lookup[name]
+	if !found {
+		return fmt.Errorf("Cannot use %s as weight, the variable does not exist", name)
+	}
+
+	v := s.variables[i]
+	if v.spssType == SpssTypeString {
+		return fmt.Errorf("Cannot use %s as weight, the variable is not numeric", name)
+	}
+
+	// The header of a stream is written with the first row
+	if s.seeker == nil && s.infoWritten {
+		return fmt.Errorf("Cannot set weight %s after the header is written", name)
+	}
+
+	s.weightIndex = v.index
+	return nil
+}
+
+// SetFileAttribute - Set a custom attribute of the file with one or more values, setting
+// an attribute again replaces its values
+// CAUTION: Attributes must be set before adding values
+func (s *SpssWriter) SetFileAttribute(name string, values ...string) error {
+	if s.err != nil {
+		return s.err
+	}
+
+	if s.infoWritten {
+		return fmt.Errorf("Cannot set file attribute %s after values are written", name)
+	}
+
+	attribute := map[string][]string{name: values}
+	if err := validateAttributes(attribute); err != nil {
+		return err
+	}
+
+	if s.attributes == nil {
+		s.attributes = make(map[string][]string)
+	}
+	s.attributes[name] = append([]string(nil), values...)
+	return nil
+}
+
+// AddMultipleResponseSet - Declare a multiple dichotomy or category set over variables that
+// are already added, the variables must all be numeric or all be strings
+// CAUTION: Sets must be added before adding values
+func (s *SpssWriter) AddMultipleResponseSet(set MultipleResponseSet) error {
+	if s.err != nil {
+		return s.err
+	}
+
+	if s.infoWritten {
+		return fmt.Errorf("Cannot add multiple response set %s after values are written", set.Name)
+	}
+
+	line, err := s.formatMRSet(&set)
+	if err != nil {
+		return err
+	}
+
+	name := line[:strings.IndexByte(line, '=')+1]
+	for _, m := range s.mrsets {
+		if strings.HasPrefix(m, name) {
+			return fmt.Errorf("Multiple response set %s already exists", name[:len(name)-1])
 		}
+	}
+
+	s.mrsets = append(s.mrsets, line)
+	return nil
+}
 
-		if err := s.bytecode.WriteString(p, int(elementCount(v.segmentWidth(se)))); err != nil {
+// SetDocuments - Set the lines of the document record, lines are cut at 80 characters
+// CAUTION: Documents must be set before adding values
+func (s *SpssWriter) SetDocuments(lines []string) error {
+	if s.err != nil {
+		return s.err
+	}
+
+	if s.infoWritten {
+		return fmt.Errorf("Cannot set documents after values are written")
+	}
+
+	s.documents = nil
+	for _, line := range lines {
+		if err := s.AddDocument(line); err != nil {
 			return err
 		}
 	}
+	return nil
+}
+
+// AddDocument - Add a line to the document record, the line is cut at 80 characters
+// CAUTION: Documents must be added before adding values
+func (s *SpssWriter) AddDocument(line string) error {
+	if s.err != nil {
+		return s.err
+	}
+
+	if s.infoWritten {
+		return fmt.Errorf("Cannot add document after values are written")
+	}
 
+	s.documents = append(s.documents, trim(line, 80))
 	return nil
 }
 
 // AddValueRow - Add a row of values to the SPSS file
 // CAUTION: All variables must be written before adding values
 func (s *SpssWriter) AddValueRow(values map[string]string) error {
-	if s.valCount == 0 {
+	if s.err != nil {
+		return s.err
+	}
+
+	if !s.infoWritten {
 		s.writeInfoRecords()
 	}
 
@@ -135,9 +432,9 @@ func (s *SpssWriter) AddValueRow(values map[string]string) error {
 
 		if !hasVal {
 			if v.spssType == SpssTypeString {
-				s.writeString(v, "")
+				s.setErr(s.writeString(v, ""))
 			} else {
-				s.bytecode.WriteMissing()
+				s.setErr(s.cases.WriteMissing())
 			}
 
 			continue
@@ -148,127 +445,101 @@ func (s *SpssWriter) AddValueRow(values map[string]string) error {
 			if len(val) > int(v.width) {
 				val = val[:v.width]
 			}
-			s.writeString(v, val)
-		case SpssTypeDate:
-			t, err := time.Parse("02-Jan-2006", val)
-			if err != nil {
-				// log.Printf("Writing missing value: %s", v.name)
-				s.bytecode.WriteMissing()
-			} else {
-				s.bytecode.WriteNumber(float64(t.Unix() + TimeOffset))
-			}
-		case SpssTypeDatetime:
-			t, err := time.Parse("02-Jan-2006 15:04:05", val)
-			if err != nil {
-				// log.Printf("Writing missing value: %s", v.name)
-				s.bytecode.WriteMissing()
-			} else {
-				s.bytecode.WriteNumber(float64(t.Unix() + TimeOffset))
-			}
+			s.setErr(s.writeString(v, val))
 		default:
-			f, err := strconv.ParseFloat(val, 64)
+			f, err := parseNumber(v.format, val)
 			if err != nil {
 				// log.Printf("Writing missing value: %s", v.name)
-				s.bytecode.WriteMissing()
+				s.setErr(s.cases.WriteMissing())
 			} else {
-				s.bytecode.WriteNumber(f)
+				s.setErr(s.cases.WriteNumber(f))
 			}
 		}
 	}
 
+	if s.err != nil {
+		return s.err
+	}
+
 	s.valCount++
 	return nil
 }
 
 func (s *SpssWriter) writeInfoRecords() {
 	s.valueLabelRecords()
+	s.documentRecord()
 	s.machineIntegerInfoRecord()
 	s.machineFloatingPointInfoRecord()
+	s.multipleResponseSetsRecords()
 	s.variableDisplayParameterRecord()
 	s.longVarNameRecords()
 	s.veryLongStringRecord()
+	if s.seeker == nil {
+		s.extendedNumberOfCasesRecord()
+	}
+	s.fileAttributesRecord()
+	s.variableAttributesRecord()
 	s.encodingRecord()
 	s.longStringValueLabelsRecord()
+	s.longStringMissingValuesRecord()
 	s.terminationRecord()
+	if s.dictionary != nil {
+		s.writeStreamHeader()
+	}
+	if s.zlib != nil {
+		s.zheaderRecord()
+	}
+	s.infoWritten = true
 }
 
 func (s *SpssWriter) headerRecord() {
-	c := time.Now()
-	s.Write(stob("$FL2", 4))                               // rec_tyoe
-	s.Write(stob("@(#) SPSS DATA FILE - xml2sav 2.0", 60)) // prod_name
-	binary.Write(s, endian, int32(2))                      // layout_code
-	binary.Write(s, endian, s.caseSize())                  // nominal_case_size
-	binary.Write(s, endian, int32(1))                      // compression
-	binary.Write(s, endian, int32(0))                      // weight_index
-	binary.Write(s, endian, int32(-1))                     // ncases
-	binary.Write(s, endian, float64(100))                  // bias
-	s.Write(stob(c.Format("02 Jan 06"), 9))                // creation_date
-	s.Write(stob(c.Format("15:04:05"), 8))                 // creation_time
-	s.Write(stob("Generated SPSS", 64))                    // file_label
-	s.Write(stob("\x00\x00\x00", 3))                       // padding
+	c := s.now()
+	if s.compression == SpssCompressionZlib {
+		s.Write(stob("$FL3", 4)) // rec_type
+	} else {
+		s.Write(stob("$FL2", 4)) // rec_type
+	}
+	s.Write(stob(s.product, 60))                      // prod_name
+	binary.Write(s, endian, int32(2))                 // layout_code
+	binary.Write(s, endian, s.caseSize())             // nominal_case_size
+	binary.Write(s, endian, int32(s.compression))     // compression
+	binary.Write(s, endian, s.weightIndex)            // weight_index
+	binary.Write(s, endian, int32(-1))                // ncases
+	binary.Write(s, endian, float64(compressionBias)) // bias
+	s.Write(stob(c.Format("02 Jan 06"), 9))           // creation_date
+	s.Write(stob(c.Format("15:04:05"), 8))            // creation_time
+	s.Write(stob(s.fileLabel, 64))                    // file_label
+	s.Write(stob("\x00\x00\x00", 3))                  // padding
 }
 
 // AddVariable - Add variables to the SPSS file
 // CAUTION: Once values are being written you cannot add any more variables
 func (s *SpssWriter) AddVariable(V *Variable) error {
-	// Check if name is empty
-	if V.Name == "" {
-		return fmt.Errorf("Name cannot be empty")
+	if s.err != nil {
+		return s.err
 	}
 
-	if len(V.Name) > 64 {
-		return fmt.Errorf("Name cannot exceed 64 characters: %s", V.Name)
-	}
-
-	matched := nameValidatorRegex.MatchString(V.Name)
-
-	if !matched {
-		return fmt.Errorf("Name %s does not meet the requirements for SPSS, please refer to https://www.ibm.com/support/knowledgecenter/en/SSLVMB_24.0.0/spss/base/syn_variables_variable_names.html", V.Name)
+	if err := V.validate(); err != nil {
+		return err
 	}
 
 	// Check if name already exists (duplicate)
-	_, exists := s.names[V.Name]
+	_, exists := s.lookup[V.Name]
 
 	if exists {
 		return fmt.Errorf("Cannot add variable with name %s since it already exists", V.Name)
 	}
 
-	// Check decimal range
-	if V.Decimal < 0 || V.Decimal > 16 {
-		return fmt.Errorf("Cannot set decimal of %d, value must be between 0 and 16", V.Decimal)
-	}
-
-	if V.Width < 0 || V.Width > 32767 {
-		return fmt.Errorf("Cannot set width of %d, value must be between 0 and 32676", V.Width)
-	}
-
-	if V.Type != SpssTypeString && V.Width > 40 {
-		return fmt.Errorf("Cannot set width of %d on type %s, value must be between 1 and 40", V.Width, V.Type)
-	}
-
-	// Check if width is set, get the default otherwise
-	if V.Width == 0 {
-		if err := V.setDefaultWidth(); err != nil {
-			return err
-		}
-	} else {
-		if V.Width <= int16(V.Decimal) {
-			return fmt.Errorf("Width cannot be less or equal to decimal")
-		}
+	v, err := V.newVariable(s.names)
+	if err != nil {
+		return err
 	}
+	v.index = s.index
 
-	v := variable{
-		index:     s.index,
-		name:      V.Name,
-		shortName: V.getShortName(s),
-		spssType:  V.Type,
-		measure:   V.getMeasure(),
-		decimal:   V.Decimal,
-		width:     V.Width,
-		format:    V.getPrint(),
-		segments:  V.getSegments(),
-		labels:    V.Labels,
-		label:     V.Label,
+	// Missing values of long strings are written in their own info record
+	recordMissing := v.missingCode
+	if v.spssType == SpssTypeString && v.segmentWidth(0) > 8 {
+		recordMissing = 0
 	}
 
 	for i := 0; i < int(v.segments); i++ {
@@ -285,10 +556,16 @@ func (s *SpssWriter) AddVariable(V *Variable) error {
 		} else {
 			binary.Write(s, endian, int32(0)) // No label
 		}
-		binary.Write(s, endian, int32(0)) // Missing values
+		if segment == 0 {
+			binary.Write(s, endian, recordMissing) // Missing values
+		} else {
+			binary.Write(s, endian, int32(0)) // No missing values
+		}
 
 		var format int32
-		if v.spssType == SpssTypeString {
+		if v.format == SpssFormatAHex {
+			format = int32(v.format)<<16 | width*2<<8
+		} else if v.spssType == SpssTypeString {
 			format = int32(v.format)<<16 | width<<8
 		} else {
 			format = int32(v.format)<<16 | int32(v.width)<<8 | int32(v.decimal)
@@ -297,7 +574,7 @@ func (s *SpssWriter) AddVariable(V *Variable) error {
 		binary.Write(s, endian, format)
 		binary.Write(s, endian, format)
 
-		s.Write(stob(v.shortName, 8))
+		s.Write(stob(v.segmentNames[segment], 8))
 
 		if segment == 0 && len(v.label) > 0 {
 			binary.Write(s, endian, int32(len(v.label))) // Label length
@@ -313,6 +590,15 @@ func (s *SpssWriter) AddVariable(V *Variable) error {
 			}
 		}
 
+		if segment == 0 && recordMissing != 0 {
+			for _, m := range v.missing {
+				binary.Write(s, endian, m) // missing_values
+			}
+			for _, m := range v.missingStrings {
+				s.Write(stob(m, 8)) // missing_values
+			}
+		}
+
 		if width > 8 {
 			count := int(elementCount(width) - 1) // Number of extra variables to store string
 			for i := 0; i < count; i++ {
@@ -325,11 +611,12 @@ func (s *SpssWriter) AddVariable(V *Variable) error {
 				s.Write(stob("        ", 8))       // name
 			}
 		}
-
-		s.variables[v.name] = v
 	}
 
-	return nil
+	s.lookup[v.name] = len(s.variables)
+	s.variables = append(s.variables, v)
+
+	return s.err
 }
 
 func (s *SpssWriter) valueLabelRecords() {
@@ -338,13 +625,8 @@ func (s *SpssWriter) valueLabelRecords() {
 			binary.Write(s, endian, int32(3))             // rec_type
 			binary.Write(s, endian, int32(len(v.labels))) // label_count
 
-			for _, label := range v.labels {
-				if v.spssType != SpssTypeNumeric {
-					binary.Write(s, endian, stob(label.Value, 8)) // value
-				} else {
-					binary.Write(s, endian, atof(label.Value)) // value
-
-				}
+			for i, label := range v.labels {
+				binary.Write(s, endian, v.labelValues[i]) // value
 				l := len(label.Desc)
 				if l > 120 {
 					l = 120
@@ -368,29 +650,75 @@ func (s *SpssWriter) valueLabelRecords() {
 	}
 }
 
+func (s *SpssWriter) documentRecord() {
+	if len(s.documents) == 0 {
+		return
+	}
+
+	binary.Write(s, endian, int32(6))                // rec_type
+	binary.Write(s, endian, int32(len(s.documents))) // n_lines
+	for _, line := range s.documents {
+		s.Write(stob(line, 80)) // line
+	}
+}
+
 func (s *SpssWriter) machineIntegerInfoRecord() {
-	binary.Write(s, endian, int32(7))     // rec_type
-	binary.Write(s, endian, int32(3))     // subtype
-	binary.Write(s, endian, int32(4))     // size
-	binary.Write(s, endian, int32(8))     // count
-	binary.Write(s, endian, int32(0))     // version_major
-	binary.Write(s, endian, int32(10))    // version_minor
-	binary.Write(s, endian, int32(1))     // version_revision
-	binary.Write(s, endian, int32(-1))    // machine_code
-	binary.Write(s, endian, int32(1))     // floating_point_rep
-	binary.Write(s, endian, int32(1))     // compression_code
-	binary.Write(s, endian, int32(2))     // endianness
-	binary.Write(s, endian, int32(65001)) // character_code
+	// SPSS writes 1 for bytecode and ZSAV alike, the header tells them apart
+	compressionCode := int32(0)
+	if s.compression != SpssCompressionNone {
+		compressionCode = 1
+	}
+
+	binary.Write(s, endian, int32(7))        // rec_type
+	binary.Write(s, endian, int32(3))        // subtype
+	binary.Write(s, endian, int32(4))        // size
+	binary.Write(s, endian, int32(8))        // count
+	binary.Write(s, endian, int32(0))        // version_major
+	binary.Write(s, endian, int32(10))       // version_minor
+	binary.Write(s, endian, int32(1))        // version_revision
+	binary.Write(s, endian, int32(-1))       // machine_code
+	binary.Write(s, endian, int32(1))        // floating_point_rep
+	binary.Write(s, endian, compressionCode) // compression_code
+	binary.Write(s, endian, int32(2))        // endianness
+	binary.Write(s, endian, int32(65001))    // character_code
 }
 
 func (s *SpssWriter) machineFloatingPointInfoRecord() {
-	binary.Write(s, endian, int32(7))                  // rec_type
-	binary.Write(s, endian, int32(4))                  // subtype
-	binary.Write(s, endian, int32(8))                  // size
-	binary.Write(s, endian, int32(3))                  // count
-	binary.Write(s, endian, float64(-math.MaxFloat64)) // sysmis
-	binary.Write(s, endian, float64(math.MaxFloat64))  // highest
-	binary.Write(s, endian, float64(-math.MaxFloat64)) // lowest
+	binary.Write(s, endian, int32(7)) // rec_type
+	binary.Write(s, endian, int32(4)) // subtype
+	binary.Write(s, endian, int32(8)) // size
+	binary.Write(s, endian, int32(3)) // count
+	binary.Write(s, endian, sysmis)   // sysmis
+	binary.Write(s, endian, highest)  // highest
+	binary.Write(s, endian, lowest)   // lowest
+}
+
+// Dichotomy sets that label categories with the counted value only exist in subtype 19,
+// older readers skip that record
+func (s *SpssWriter) multipleResponseSetsRecords() {
+	var sets, extended string
+	for _, line := range s.mrsets {
+		if line[strings.IndexByte(line, '=')+1] == 'E' {
+			extended += line
+		} else {
+			sets += line
+		}
+	}
+
+	s.multipleResponseSetsRecord(7, sets)
+	s.multipleResponseSetsRecord(19, extended)
+}
+
+func (s *SpssWriter) multipleResponseSetsRecord(subtype int32, data string) {
+	if data == "" {
+		return
+	}
+
+	binary.Write(s, endian, int32(7))         // rec_type
+	binary.Write(s, endian, subtype)          // subtype
+	binary.Write(s, endian, int32(1))         // size
+	binary.Write(s, endian, int32(len(data))) // count
+	s.Write([]byte(data))                     // mrsets
 }
 
 func (s *SpssWriter) varCount() int32 {
@@ -412,10 +740,8 @@ func (s *SpssWriter) variableDisplayParameterRecord() {
 			if v.spssType == SpssTypeString {
 				if se != 0 {
 					binary.Write(s, endian, int32(8)) // width
-				} else if int(v.width) <= 40 {
-					binary.Write(s, endian, int32(v.width)) // width
 				} else {
-					binary.Write(s, endian, int32(40))
+					binary.Write(s, endian, int32(v.width)) // width
 				}
 				binary.Write(s, endian, int32(0)) // alignment (left)
 			} else {
@@ -432,15 +758,13 @@ func (s *SpssWriter) longVarNameRecords() {
 	binary.Write(s, endian, int32(1))  // size
 
 	buf := bytes.Buffer{}
-	i := 0
-	for short, long := range s.names {
-		buf.Write([]byte(short))
+	for i, v := range s.variables {
+		buf.Write([]byte(v.shortName))
 		buf.Write([]byte("="))
-		buf.Write([]byte(long))
-		if i < len(s.names)-1 {
+		buf.Write([]byte(v.name))
+		if i < len(s.variables)-1 {
 			buf.Write([]byte{9})
 		}
-		i++
 	}
 	binary.Write(s, endian, int32(buf.Len()))
 	s.Write(buf.Bytes())
@@ -469,7 +793,7 @@ func (s *SpssWriter) veryLongStringRecord() {
 		if v.segments > 1 {
 			buf.Write([]byte(v.shortName))
 			buf.Write([]byte("="))
-			buf.Write(stobp(strconv.Itoa(0), 5, 0))
+			buf.Write([]byte(fmt.Sprintf("%05d", v.width)))
 			buf.Write([]byte{0, 9})
 		}
 	}
@@ -477,6 +801,51 @@ func (s *SpssWriter) veryLongStringRecord() {
 	s.Write(buf.Bytes())
 }
 
+func (s *SpssWriter) extendedNumberOfCasesRecord() {
+	binary.Write(s, endian, int32(7))  // rec_type
+	binary.Write(s, endian, int32(16)) // subtype
+	binary.Write(s, endian, int32(8))  // size
+	binary.Write(s, endian, int32(2))  // count
+	binary.Write(s, endian, int64(1))  // unknown
+	binary.Write(s, endian, int64(-1)) // ncases64, unknown for streams
+}
+
+func (s *SpssWriter) fileAttributesRecord() {
+	if len(s.attributes) == 0 {
+		return
+	}
+
+	data := formatAttributes(s.attributes)
+	binary.Write(s, endian, int32(7))         // rec_type
+	binary.Write(s, endian, int32(17))        // subtype
+	binary.Write(s, endian, int32(1))         // size
+	binary.Write(s, endian, int32(len(data))) // count
+	s.Write([]byte(data))                     // attributes
+}
+
+func (s *SpssWriter) variableAttributesRecord() {
+	buf := bytes.Buffer{}
+	for _, v := range s.variables {
+		if v.attributes == "" {
+			continue
+		}
+		if buf.Len() > 0 {
+			buf.WriteByte('/')
+		}
+		buf.WriteString(v.name + ":" + v.attributes)
+	}
+
+	if buf.Len() == 0 {
+		return
+	}
+
+	binary.Write(s, endian, int32(7))         // rec_type
+	binary.Write(s, endian, int32(18))        // subtype
+	binary.Write(s, endian, int32(1))         // size
+	binary.Write(s, endian, int32(buf.Len())) // count
+	s.Write(buf.Bytes())                      // var_attributes
+}
+
 func (s *SpssWriter) encodingRecord() {
 	binary.Write(s, endian, int32(7))  // rec_type
 	binary.Write(s, endian, int32(20)) // subtype
@@ -522,22 +891,86 @@ func (s *SpssWriter) longStringValueLabelsRecord() {
 	s.Write(buf.Bytes())
 }
 
+func (s *SpssWriter) longStringMissingValuesRecord() {
+	buf := new(bytes.Buffer)
+	for _, v := range s.variables {
+		if v.spssType == SpssTypeString && v.segmentWidth(0) > 8 && len(v.missingStrings) > 0 {
+			binary.Write(buf, endian, int32(len(v.name))) // var_name_len
+			buf.Write([]byte(v.name))                     // var_name
+			buf.WriteByte(byte(len(v.missingStrings)))    // n_missing_values
+			binary.Write(buf, endian, int32(8))           // value_len
+			for _, m := range v.missingStrings {
+				buf.Write(stob(m, 8)) // values
+			}
+		}
+	}
+
+	if buf.Len() == 0 {
+		// There are no long string missing values so don't write the record
+		return
+	}
+
+	binary.Write(s, endian, int32(7))         // rec_type
+	binary.Write(s, endian, int32(22))        // subtype
+	binary.Write(s, endian, int32(1))         // size
+	binary.Write(s, endian, int32(buf.Len())) // count
+	s.Write(buf.Bytes())
+}
+
 func (s *SpssWriter) terminationRecord() {
 	binary.Write(s, endian, int32(999)) // rec_type
 	binary.Write(s, endian, int32(0))   // filler
 }
 
-// If you use a buffer, supply it as the flusher argument
-// After this close the file
-func (s *SpssWriter) updateHeaderNCases() {
-	s.bytecode.Flush()
+// Write the header of a stream now the dictionary is complete, followed by
+// the dictionary that was held back
+func (s *SpssWriter) writeStreamHeader() {
+	s.Flush()
+	s.writer.Reset(s.counter)
+	s.headerRecord()
+	s.Write(s.dictionary.Bytes())
+	s.dictionary = nil
+}
+
+func (s *SpssWriter) zheaderRecord() {
+	s.zheaderOffset = s.offset()
+	binary.Write(s, endian, s.zheaderOffset) // zheader_ofs
+	binary.Write(s, endian, int64(0))        // ztrailer_ofs, written in Finish
+	binary.Write(s, endian, int64(0))        // ztrailer_len, written in Finish
+	s.zlib.start(s.zheaderOffset)
+}
+
+// Rewrite the header fields that are only known once all cases are written
+func (s *SpssWriter) updateHeader() {
 	s.Flush()
-	s.seeker.Seek(80, 0)
-	binary.Write(s.seeker, endian, int32(s.valCount)) // ncases in headerRecord
+	s.patch(headerCaseSizeOffset, s.caseSize())     // nominal_case_size
+	s.patch(headerWeightIndexOffset, s.weightIndex) // weight_index
+	s.patch(headerNCasesOffset, int32(s.valCount))  // ncases
 }
 
-// Finish - Execute this once all variables and values are written to complete the file
-func (s *SpssWriter) Finish() {
-	s.updateHeaderNCases()
+// Rewrite the location of the ztrailer in the zheader
+func (s *SpssWriter) updateZheader(trailerOffset int64, trailerLength int64) {
+	s.Flush()
+	s.patch(s.zheaderOffset+8, trailerOffset)  // ztrailer_ofs
+	s.patch(s.zheaderOffset+16, trailerLength) // ztrailer_len
+}
+
+// Finish - Execute this once all variables and values are written to complete the file,
+// returns the first error that occurred while writing
+func (s *SpssWriter) Finish() error {
+	if !s.infoWritten {
+		s.writeInfoRecords()
+	}
+	s.setErr(s.cases.Flush())
+	if s.zlib != nil {
+		s.setErr(s.zlib.Flush())
+		trailerOffset := s.offset()
+		s.setErr(s.zlib.writeTrailer(compressionBias))
+		s.updateZheader(trailerOffset, s.offset()-trailerOffset)
+	}
+	if s.seeker != nil {
+		s.updateHeader()
+	}
 	s.Flush()
+	return s.err
 }
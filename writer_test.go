@@ -0,0 +1,349 @@
+package gospss
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVeryLongStringMaxWidth(t *testing.T) {
+	value := strings.Repeat("abcdefghij", 3276) + "abcdefg"
+
+	file := &memFile{}
+	spssWriter, err := NewSpssWriter(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := spssWriter.AddVariable(&Variable{Name: "TEXT", Type: SpssTypeString, Width: 32767}); err != nil {
+		t.Fatal(err)
+	}
+	if err := spssWriter.AddValueRow(map[string]string{"TEXT": value}); err != nil {
+		t.Fatal(err)
+	}
+	if err := spssWriter.Finish(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewSpssReader(bytes.NewReader(file.data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := r.Variables(); len(v) != 1 || v[0].Width != 32767 {
+		t.Fatalf("Variables() = %+v, want one variable of width 32767", v)
+	}
+
+	row, err := r.ReadRow()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if row["TEXT"] != value {
+		t.Errorf("Read %d bytes, want the %d bytes written", len(row["TEXT"]), len(value))
+	}
+}
+
+func TestWithProductName(t *testing.T) {
+	tests := []struct {
+		product string
+		want    string
+	}{
+		{"@(#) SPSS DATA FILE - exporter 1.2", "@(#) SPSS DATA FILE - exporter 1.2"},
+		{"exporter 1.2", "@(#) SPSS DATA FILE exporter 1.2"},
+		{"", "@(#) SPSS DATA FILE "},
+		{strings.Repeat("x", 40), "@(#) SPSS DATA FILE " + strings.Repeat("x", 40)},
+	}
+
+	for _, tt := range tests {
+		data := writeTestFile(t, SpssCompressionBytecode, false, WithProductName(tt.product))
+
+		// prod_name follows the rec_type and is padded with spaces to 60 bytes
+		if got := string(data[4:64]); got != tt.want+strings.Repeat(" ", 60-len(tt.want)) {
+			t.Errorf("WithProductName(%q) wrote %q, want %q", tt.product, got, tt.want)
+		}
+	}
+
+	for _, product := range []string{strings.Repeat("x", 41), "@(#) SPSS DATA FILE" + strings.Repeat("x", 42)} {
+		if _, err := NewSpssWriter(&memFile{}, WithProductName(product)); err == nil {
+			t.Errorf("WithProductName(%q) accepted a name longer than 60 bytes", product)
+		}
+	}
+}
+
+func TestWriterClock(t *testing.T) {
+	now := time.Date(2021, 3, 14, 15, 9, 26, 0, time.UTC)
+	clock := WithClock(func() time.Time { return now })
+
+	for _, compression := range []SpssCompression{SpssCompressionNone, SpssCompressionBytecode, SpssCompressionZlib} {
+		first := writeTestFile(t, compression, false, clock)
+		second := writeTestFile(t, compression, false, clock)
+		if !bytes.Equal(first, second) {
+			t.Errorf("Output with compression %d differs with the same clock", compression)
+		}
+
+		// creation_date and creation_time follow the header fields
+		if got := string(first[headerNCasesOffset+12 : headerNCasesOffset+29]); got != "14 Mar 2115:09:26" {
+			t.Errorf("Creation date and time = %q, want %q", got, "14 Mar 2115:09:26")
+		}
+	}
+
+	if _, err := NewSpssWriter(&memFile{}, WithClock(nil)); err == nil {
+		t.Error("NewSpssWriter() accepted a nil clock")
+	}
+}
+
+func TestAddVariableLabels(t *testing.T) {
+	tests := []struct {
+		name     string
+		variable Variable
+		err      string // Empty when the labels are valid
+	}{
+		{"number", Variable{Name: "AGE", Type: SpssTypeNumeric, Labels: []Label{{Value: "1", Desc: "One"}, {Value: "-2.5", Desc: "Less"}}}, ""},
+		{"comma", Variable{Name: "INCOME", Type: SpssTypeNumeric, Format: SpssFormatComma, Decimal: 1,
+			Labels: []Label{{Value: "1,234.5", Desc: "Average"}}}, ""},
+		{"date", Variable{Name: "VISIT", Type: SpssTypeDate, Format: SpssFormatADate,
+			Labels: []Label{{Value: "12/31/2020", Desc: "New year's eve"}}}, ""},
+		{"string", Variable{Name: "CODE", Type: SpssTypeString, Width: 3, Labels: []Label{{Value: "ABC", Desc: "Code"}}}, ""},
+
+		{"text on number", Variable{Name: "AGE", Type: SpssTypeNumeric, Labels: []Label{{Value: "young", Desc: "Young"}}},
+			`Invalid value "young" of label "Young" on variable AGE`},
+		{"bad date", Variable{Name: "VISIT", Type: SpssTypeDate, Format: SpssFormatADate,
+			Labels: []Label{{Value: "31/12/2020", Desc: "New year's eve"}}},
+			`Invalid value "31/12/2020" of label "New year's eve" on variable VISIT`},
+		{"date on number", Variable{Name: "VISIT", Type: SpssTypeDate, Labels: []Label{{Value: "yesterday", Desc: "Yesterday"}}},
+			`Invalid value "yesterday" of label "Yesterday" on variable VISIT`},
+		{"long string", Variable{Name: "CODE", Type: SpssTypeString, Width: 3, Labels: []Label{{Value: "ABCD", Desc: "Code"}}},
+			`Value "ABCD" of label "Code" on variable CODE exceeds the width of 3 bytes`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spssWriter, err := NewSpssWriter(&memFile{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = spssWriter.AddVariable(&tt.variable)
+			if tt.err == "" {
+				if err != nil {
+					t.Errorf("AddVariable() failed: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("AddVariable() error = %v, want it to contain %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestSetWeight(t *testing.T) {
+	tests := []struct {
+		name   string
+		stream bool
+		late   bool  // Set the weight after the first row
+		want   int32 // weight_index in the header
+	}{
+		{"before rows", false, false, 5},
+		{"after rows", false, true, 5},
+		{"stream before rows", true, false, 5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var spssWriter *SpssWriter
+			var err error
+			file := &memFile{}
+			var buf bytes.Buffer
+			if tt.stream {
+				spssWriter, err = NewSpssStreamWriter(&buf)
+			} else {
+				spssWriter, err = NewSpssWriter(file)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// The weight follows a string of three elements, its dictionary index is 5
+			for _, V := range []Variable{
+				{Name: "ID", Type: SpssTypeNumeric},
+				{Name: "NAME", Type: SpssTypeString, Width: 20},
+				{Name: "WEIGHT", Type: SpssTypeNumeric, Decimal: 2},
+			} {
+				V := V
+				if err := spssWriter.AddVariable(&V); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if !tt.late {
+				if err := spssWriter.SetWeight("WEIGHT"); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := spssWriter.AddValueRow(map[string]string{"ID": "1", "NAME": "John", "WEIGHT": "1.5"}); err != nil {
+				t.Fatal(err)
+			}
+			if tt.late {
+				if err := spssWriter.SetWeight("WEIGHT"); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := spssWriter.Finish(); err != nil {
+				t.Fatal(err)
+			}
+
+			data := file.data
+			if tt.stream {
+				data = buf.Bytes()
+			}
+			if got := int32(endian.Uint32(data[headerWeightIndexOffset:])); got != tt.want {
+				t.Errorf("weight_index = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetWeightInvalid(t *testing.T) {
+	var buf bytes.Buffer
+	spssWriter, err := NewSpssStreamWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, V := range []Variable{{Name: "NAME", Type: SpssTypeString, Width: 20}, {Name: "WEIGHT", Type: SpssTypeNumeric}} {
+		V := V
+		if err := spssWriter.AddVariable(&V); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := spssWriter.SetWeight("NAME"); err == nil || !strings.Contains(err.Error(), "not numeric") {
+		t.Errorf("SetWeight() of a string variable returned %v, want an error", err)
+	}
+	if err := spssWriter.SetWeight("UNKNOWN"); err == nil || !strings.Contains(err.Error(), "does not exist") {
+		t.Errorf("SetWeight() of an unknown variable returned %v, want an error", err)
+	}
+
+	// A stream cannot go back to the header once it is written with the first row
+	if err := spssWriter.AddValueRow(map[string]string{"NAME": "John", "WEIGHT": "1"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := spssWriter.SetWeight("WEIGHT"); err == nil || !strings.Contains(err.Error(), "after the header is written") {
+		t.Errorf("SetWeight() on a stream after the header returned %v, want an error", err)
+	}
+	if err := spssWriter.Finish(); err != nil {
+		t.Fatal(err)
+	}
+	if got := int32(endian.Uint32(buf.Bytes()[headerWeightIndexOffset:])); got != 0 {
+		t.Errorf("weight_index = %d, want 0", got)
+	}
+}
+
+var errWriteFailed = errors.New("write failed")
+
+// failingFile accepts limit bytes and fails every write after that
+type failingFile struct {
+	memFile
+	limit int
+}
+
+func (f *failingFile) Write(p []byte) (int, error) {
+	if len(f.data)+len(p) > f.limit {
+		return 0, errWriteFailed
+	}
+	return f.memFile.Write(p)
+}
+
+// Call of the writer in TestWriterStickyError
+type writerStep struct {
+	call string
+	run  func() error
+}
+
+// Returns the calls that write a dictionary and twenty cases of 32 KB
+func writerSteps(spssWriter *SpssWriter) []writerStep {
+	text := strings.Repeat("x", 32767)
+
+	steps := []writerStep{
+		{"AddVariable", func() error {
+			return spssWriter.AddVariable(&Variable{Name: "TEXT", Type: SpssTypeString, Width: 32767})
+		}},
+		{"AddVariable", func() error { return spssWriter.AddVariable(&Variable{Name: "Q1", Type: SpssTypeNumeric}) }},
+		{"AddVariable", func() error { return spssWriter.AddVariable(&Variable{Name: "Q2", Type: SpssTypeNumeric}) }},
+		{"SetWeight", func() error { return spssWriter.SetWeight("Q1") }},
+		{"AddDocument", func() error { return spssWriter.AddDocument("Fieldwork March 2021") }},
+		{"SetFileAttribute", func() error { return spssWriter.SetFileAttribute("Source", "CRM export") }},
+		{"AddMultipleResponseSet", func() error {
+			return spssWriter.AddMultipleResponseSet(MultipleResponseSet{Name: "set", Type: SpssMRSetCategory, Variables: []string{"Q1", "Q2"}})
+		}},
+	}
+	for i := 0; i < 10; i++ {
+		steps = append(steps,
+			writerStep{"AddValueRow", func() error { return spssWriter.AddValueRow(map[string]string{"TEXT": text, "Q1": "1"}) }},
+			writerStep{"AddRow", func() error { return spssWriter.AddRow(Row{"TEXT": Text(text), "Q2": Number(2)}) }},
+		)
+	}
+	steps = append(steps, writerStep{"Finish", spssWriter.Finish})
+
+	return steps
+}
+
+func TestWriterStickyError(t *testing.T) {
+	// Size of the file when every write succeeds
+	file := &failingFile{limit: math.MaxInt32}
+	spssWriter, err := NewSpssWriter(file, WithCompression(SpssCompressionNone))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, step := range writerSteps(spssWriter) {
+		if err := step.run(); err != nil {
+			t.Fatalf("%s failed: %v", step.call, err)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		limit   int
+		failsIn string // First call that returns the error
+	}{
+		{"dictionary", 0, "AddVariable"}, // The segments of TEXT fill the buffer
+		{"cases", 150000, "AddValueRow"},
+		{"finish", len(file.data) - 1, "Finish"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spssWriter, err := NewSpssWriter(&failingFile{limit: tt.limit}, WithCompression(SpssCompressionNone))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Calls succeed until the write fails, from then on every call returns that error
+			failed := false
+			for _, step := range writerSteps(spssWriter) {
+				err := step.run()
+				if err != nil && err != errWriteFailed {
+					t.Fatalf("%s returned %v, want %v", step.call, err, errWriteFailed)
+				}
+				if failed && err == nil {
+					t.Fatalf("%s succeeded after the write failed", step.call)
+				}
+				if !failed && err != nil && step.call != tt.failsIn {
+					t.Errorf("%s failed first, want %s", step.call, tt.failsIn)
+				}
+				failed = failed || err != nil
+			}
+			if !failed {
+				t.Fatal("Every call succeeded")
+			}
+
+			if err := spssWriter.AddVariable(&Variable{Name: "Q3", Type: SpssTypeNumeric}); err != errWriteFailed {
+				t.Errorf("AddVariable() after Finish() returned %v, want %v", err, errWriteFailed)
+			}
+			if err := spssWriter.Finish(); err != errWriteFailed {
+				t.Errorf("Finish() returned %v, want %v", err, errWriteFailed)
+			}
+		})
+	}
+}
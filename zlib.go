@@ -0,0 +1,221 @@
+package gospss
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Uncompressed size of every block but the last in a ZSAV file
+const zlibBlockSize = 0x3ff000
+
+type zlibBlock struct {
+	uncompressedOffset int64
+	compressedOffset   int64
+	uncompressedSize   int32
+	compressedSize     int32
+}
+
+// zlibWriter deflates the bytecode of a ZSAV file in separate zlib blocks
+type zlibWriter struct {
+	io.Writer
+	block              bytes.Buffer
+	compressed         bytes.Buffer
+	uncompressedOffset int64
+	compressedOffset   int64
+	blocks             []zlibBlock
+}
+
+func newZlibWriter(w io.Writer) *zlibWriter {
+	return &zlibWriter{Writer: w}
+}
+
+// Set the offsets of the first block given the offset of the zheader
+func (w *zlibWriter) start(zheaderOffset int64) {
+	w.uncompressedOffset = zheaderOffset
+	w.compressedOffset = zheaderOffset + 24
+}
+
+func (w *zlibWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		c := zlibBlockSize - w.block.Len()
+		if c > len(p) {
+			c = len(p)
+		}
+		w.block.Write(p[:c])
+		p = p[c:]
+
+		if w.block.Len() == zlibBlockSize {
+			if err := w.writeBlock(); err != nil {
+				return n - len(p), err
+			}
+		}
+	}
+	return n, nil
+}
+
+func (w *zlibWriter) writeBlock() error {
+	if w.block.Len() == 0 {
+		return nil
+	}
+
+	w.compressed.Reset()
+	z := zlib.NewWriter(&w.compressed)
+	if _, err := z.Write(w.block.Bytes()); err != nil {
+		return err
+	}
+	if err := z.Close(); err != nil {
+		return err
+	}
+	if _, err := w.Writer.Write(w.compressed.Bytes()); err != nil {
+		return err
+	}
+
+	block := zlibBlock{
+		uncompressedOffset: w.uncompressedOffset,
+		compressedOffset:   w.compressedOffset,
+		uncompressedSize:   int32(w.block.Len()),
+		compressedSize:     int32(w.compressed.Len()),
+	}
+	w.blocks = append(w.blocks, block)
+	w.uncompressedOffset += int64(block.uncompressedSize)
+	w.compressedOffset += int64(block.compressedSize)
+	w.block.Reset()
+
+	return nil
+}
+
+// Flush compresses the remaining data in a final, smaller block
+func (w *zlibWriter) Flush() error {
+	return w.writeBlock()
+}
+
+func (w *zlibWriter) writeTrailer(bias float64) error {
+	buf := new(bytes.Buffer)
+	binary.Write(buf, endian, int64(-bias))         // bias
+	binary.Write(buf, endian, int64(0))             // zero
+	binary.Write(buf, endian, int32(zlibBlockSize)) // block_size
+	binary.Write(buf, endian, int32(len(w.blocks))) // n_blocks
+	for _, b := range w.blocks {
+		binary.Write(buf, endian, b.uncompressedOffset) // uncompressed_ofs
+		binary.Write(buf, endian, b.compressedOffset)   // compressed_ofs
+		binary.Write(buf, endian, b.uncompressedSize)   // uncompressed_size
+		binary.Write(buf, endian, b.compressedSize)     // compressed_size
+	}
+	_, err := w.Writer.Write(buf.Bytes())
+	return err
+}
+
+// zlibReader inflates the blocks of a ZSAV file, the underlying reader must be
+// positioned at the first block
+type zlibReader struct {
+	io.Reader
+	blocks  []zlibBlock
+	next    int       // Index of the next block
+	current io.Reader // Inflated data of the current block
+	size    int64     // Inflated size of the current block so far
+}
+
+func newZlibReader(r io.Reader, blocks []zlibBlock) *zlibReader {
+	return &zlibReader{Reader: r, blocks: blocks}
+}
+
+func (r *zlibReader) Read(p []byte) (int, error) {
+	for {
+		if r.current != nil {
+			n, err := r.current.Read(p)
+			r.size += int64(n)
+			if err == io.EOF {
+				block := r.blocks[r.next-1]
+				if r.size != int64(block.uncompressedSize) {
+					return n, fmt.Errorf("ZSAV block %d inflates to %d bytes instead of %d", r.next, r.size, block.uncompressedSize)
+				}
+				r.current = nil
+				err = nil
+			}
+			if n > 0 || err != nil {
+				return n, err
+			}
+			continue
+		}
+
+		if r.next >= len(r.blocks) {
+			return 0, io.EOF
+		}
+
+		block := r.blocks[r.next]
+		r.next++
+		r.size = 0
+
+		z, err := zlib.NewReader(io.LimitReader(r.Reader, int64(block.compressedSize)))
+		if err != nil {
+			return 0, fmt.Errorf("Cannot inflate ZSAV block %d: %v", r.next, err)
+		}
+		r.current = z
+	}
+}
+
+// Parse and validate the ztrailer given the zheader offsets and the header bias
+func parseZtrailer(order binary.ByteOrder, trailer []byte, zheaderOffset int64, trailerOffset int64, bias float64) ([]zlibBlock, error) {
+	if len(trailer) < 24 || len(trailer)%24 != 0 {
+		return nil, fmt.Errorf("Invalid ztrailer length %d", len(trailer))
+	}
+
+	trailerBias := int64(order.Uint64(trailer[0:]))
+	zero := int64(order.Uint64(trailer[8:]))
+	blockSize := int32(order.Uint32(trailer[16:]))
+	n := int32(order.Uint32(trailer[20:]))
+
+	if float64(-trailerBias) != bias {
+		return nil, fmt.Errorf("ZSAV bias %d does not match the header bias %v", -trailerBias, bias)
+	}
+	if zero != 0 {
+		return nil, fmt.Errorf("ZSAV trailer has %d instead of 0 after the bias", zero)
+	}
+	if blockSize <= 0 {
+		return nil, fmt.Errorf("Invalid ZSAV block size %d", blockSize)
+	}
+	if int(n) != len(trailer)/24-1 {
+		return nil, fmt.Errorf("ZSAV trailer lists %d blocks but has room for %d", n, len(trailer)/24-1)
+	}
+
+	blocks := make([]zlibBlock, n)
+	uncompressedOffset := zheaderOffset
+	compressedOffset := zheaderOffset + 24
+
+	for i := range blocks {
+		b := trailer[24+i*24:]
+		block := zlibBlock{
+			uncompressedOffset: int64(order.Uint64(b[0:])),
+			compressedOffset:   int64(order.Uint64(b[8:])),
+			uncompressedSize:   int32(order.Uint32(b[16:])),
+			compressedSize:     int32(order.Uint32(b[20:])),
+		}
+
+		if block.uncompressedOffset != uncompressedOffset {
+			return nil, fmt.Errorf("ZSAV block %d has uncompressed offset %d instead of %d", i+1, block.uncompressedOffset, uncompressedOffset)
+		}
+		if block.compressedOffset != compressedOffset {
+			return nil, fmt.Errorf("ZSAV block %d has compressed offset %d instead of %d", i+1, block.compressedOffset, compressedOffset)
+		}
+		if block.uncompressedSize <= 0 || block.uncompressedSize > blockSize {
+			return nil, fmt.Errorf("ZSAV block %d has invalid uncompressed size %d", i+1, block.uncompressedSize)
+		}
+		if block.compressedSize <= 0 {
+			return nil, fmt.Errorf("ZSAV block %d has invalid compressed size %d", i+1, block.compressedSize)
+		}
+
+		uncompressedOffset += int64(block.uncompressedSize)
+		compressedOffset += int64(block.compressedSize)
+		blocks[i] = block
+	}
+
+	if compressedOffset != trailerOffset {
+		return nil, fmt.Errorf("ZSAV blocks end at %d but the trailer starts at %d", compressedOffset, trailerOffset)
+	}
+
+	return blocks, nil
+}
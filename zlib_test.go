@@ -0,0 +1,250 @@
+package gospss
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestZlibWriterBlocks(t *testing.T) {
+	data := make([]byte, zlibBlockSize+1000)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+
+	var buf bytes.Buffer
+	w := newZlibWriter(&buf)
+	w.start(1000)
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(w.blocks) != 2 {
+		t.Fatalf("Wrote %d blocks, want 2", len(w.blocks))
+	}
+	if w.blocks[0].uncompressedSize != zlibBlockSize || w.blocks[1].uncompressedSize != 1000 {
+		t.Errorf("Block sizes are %d and %d, want %d and 1000", w.blocks[0].uncompressedSize, w.blocks[1].uncompressedSize, zlibBlockSize)
+	}
+
+	// The blocks follow the zheader and each other, every block inflates on its own
+	uncompressedOffset, compressedOffset := int64(1000), int64(1024)
+	var inflated []byte
+	for i, b := range w.blocks {
+		if b.uncompressedOffset != uncompressedOffset || b.compressedOffset != compressedOffset {
+			t.Errorf("Block %d has offsets %d and %d, want %d and %d", i, b.uncompressedOffset, b.compressedOffset,
+				uncompressedOffset, compressedOffset)
+		}
+		uncompressedOffset += int64(b.uncompressedSize)
+		compressedOffset += int64(b.compressedSize)
+
+		start := b.compressedOffset - 1024
+		z, err := zlib.NewReader(bytes.NewReader(buf.Bytes()[start : start+int64(b.compressedSize)]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		p, err := ioutil.ReadAll(z)
+		if err != nil {
+			t.Fatal(err)
+		}
+		inflated = append(inflated, p...)
+	}
+	if !bytes.Equal(inflated, data) {
+		t.Error("Inflated blocks differ from the data written")
+	}
+
+	trailerOffset := int64(buf.Len()) + 1024
+	if err := w.writeTrailer(compressionBias); err != nil {
+		t.Fatal(err)
+	}
+	trailer := buf.Bytes()[trailerOffset-1024:]
+	if len(trailer) != 24*3 {
+		t.Fatalf("Trailer has %d bytes, want %d", len(trailer), 24*3)
+	}
+
+	blocks, err := parseZtrailer(endian, trailer, 1000, trailerOffset, compressionBias)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(blocks, w.blocks) {
+		t.Errorf("Parsed blocks %+v, want %+v", blocks, w.blocks)
+	}
+}
+
+func TestCompressionCodes(t *testing.T) {
+	tests := []struct {
+		name        string
+		compression SpssCompression
+		header      int32 // Compression in the header
+		info        int32 // compression_code of the machine integer info record
+	}{
+		{"none", SpssCompressionNone, 0, 0},
+		{"bytecode", SpssCompressionBytecode, 1, 1},
+		{"zlib", SpssCompressionZlib, 2, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := writeTestFile(t, tt.compression, false)
+
+			if header := int32(binary.LittleEndian.Uint32(data[72:])); header != tt.header {
+				t.Errorf("Header compression = %d, want %d", header, tt.header)
+			}
+
+			info := findRecord(t, data, 7, 3)
+			if code := int32(binary.LittleEndian.Uint32(data[info+36:])); code != tt.info {
+				t.Errorf("compression_code = %d, want %d", code, tt.info)
+			}
+		})
+	}
+}
+
+func TestZheader(t *testing.T) {
+	data := writeTestFile(t, SpssCompressionZlib, false)
+
+	// The zheader follows the termination record and its filler
+	termination := findRecord(t, data, 999, 0)
+	zheaderOffset := int64(termination + 8)
+	zheader := data[zheaderOffset:]
+
+	if offset := int64(binary.LittleEndian.Uint64(zheader[0:])); offset != zheaderOffset {
+		t.Errorf("zheader_ofs = %d, want %d", offset, zheaderOffset)
+	}
+
+	trailerOffset := int64(binary.LittleEndian.Uint64(zheader[8:]))
+	trailerLength := int64(binary.LittleEndian.Uint64(zheader[16:]))
+	if trailerOffset+trailerLength != int64(len(data)) {
+		t.Errorf("ztrailer at %d with %d bytes does not end the file of %d bytes", trailerOffset, trailerLength, len(data))
+	}
+
+	blocks, err := parseZtrailer(endian, data[trailerOffset:], zheaderOffset, trailerOffset, compressionBias)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(blocks) != 1 {
+		t.Errorf("ztrailer lists %d blocks, want 1", len(blocks))
+	}
+}
+
+func TestZsavMultipleBlocks(t *testing.T) {
+	file := &memFile{}
+	spssWriter, err := NewSpssWriter(file, WithCompression(SpssCompressionZlib))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"XX", "YY"} {
+		if err := spssWriter.AddVariable(&Variable{Name: name, Type: SpssTypeNumeric, Decimal: 2}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Values that are not integers take 8 bytes each, enough for a second block
+	const n = 300000
+	for i := 0; i < n; i++ {
+		if err := spssWriter.AddRow(Row{"XX": Number(float64(i) + 0.5), "YY": Number(-float64(i) - 0.25)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := spssWriter.Finish(); err != nil {
+		t.Fatal(err)
+	}
+	if len(spssWriter.zlib.blocks) < 2 {
+		t.Fatalf("Wrote %d blocks, want at least 2", len(spssWriter.zlib.blocks))
+	}
+
+	r, err := NewSpssReader(bytes.NewReader(file.data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := r.Cases()
+	count := 0
+	for cases.Next() {
+		x, _ := cases.Row().Float("XX")
+		y, _ := cases.Row().Float("YY")
+		if x != float64(count)+0.5 || y != -float64(count)-0.25 {
+			t.Fatalf("Case %d = %v, %v", count+1, x, y)
+		}
+		count++
+	}
+	if err := cases.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if count != n {
+		t.Errorf("Read %d cases, want %d", count, n)
+	}
+}
+
+func TestZsavRequiresSeeker(t *testing.T) {
+	data := writeTestFile(t, SpssCompressionZlib, false)
+
+	_, err := NewSpssReader(struct{ io.Reader }{bytes.NewReader(data)})
+	if err == nil || !strings.Contains(err.Error(), "io.ReadSeeker") {
+		t.Errorf("NewSpssReader() error = %v, want an error about io.ReadSeeker", err)
+	}
+}
+
+func TestZsavCorruptBlock(t *testing.T) {
+	data := writeTestFile(t, SpssCompressionZlib, false)
+
+	// Damage the zlib stream of the only block, right after the zheader
+	zheaderOffset := findRecord(t, data, 999, 0) + 8
+	for i := zheaderOffset + 26; i < zheaderOffset+40; i++ {
+		data[i] ^= 0xff
+	}
+
+	r, err := NewSpssReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := r.Cases()
+	for cases.Next() {
+	}
+	if cases.Err() == nil {
+		t.Error("Reading a corrupt block succeeded, want an error")
+	}
+}
+
+func TestParseZtrailerMalformed(t *testing.T) {
+	var buf bytes.Buffer
+	w := newZlibWriter(&buf)
+	w.start(1000)
+	w.Write(bytes.Repeat([]byte("spss"), 1000))
+	w.Flush()
+	trailerOffset := int64(1024 + buf.Len())
+	w.writeTrailer(compressionBias)
+	valid := append([]byte(nil), buf.Bytes()[trailerOffset-1024:]...)
+
+	tests := []struct {
+		name    string
+		corrupt func(trailer []byte) []byte
+		err     string
+	}{
+		{"short", func(b []byte) []byte { return b[:20] }, "Invalid ztrailer length"},
+		{"bias", func(b []byte) []byte { endian.PutUint64(b[0:], uint64(50)); return b }, "does not match the header bias"},
+		{"zero", func(b []byte) []byte { endian.PutUint64(b[8:], 1); return b }, "instead of 0"},
+		{"block size", func(b []byte) []byte { endian.PutUint32(b[16:], 0); return b }, "Invalid ZSAV block size"},
+		{"block count", func(b []byte) []byte { endian.PutUint32(b[20:], 5); return b }, "lists 5 blocks"},
+		{"uncompressed offset", func(b []byte) []byte { endian.PutUint64(b[24:], 0); return b }, "uncompressed offset"},
+		{"compressed offset", func(b []byte) []byte { endian.PutUint64(b[32:], 0); return b }, "compressed offset"},
+		{"uncompressed size", func(b []byte) []byte { endian.PutUint32(b[40:], 0); return b }, "invalid uncompressed size"},
+		{"compressed size", func(b []byte) []byte { endian.PutUint32(b[44:], 1); return b }, "but the trailer starts"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			trailer := tt.corrupt(append([]byte(nil), valid...))
+			_, err := parseZtrailer(endian, trailer, 1000, trailerOffset, compressionBias)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("parseZtrailer() error = %v, want it to contain %q", err, tt.err)
+			}
+		})
+	}
+}